@@ -19,8 +19,10 @@ import (
 	"github.com/swkisdust/torrentremover/internal/client/delugex"
 	"github.com/swkisdust/torrentremover/internal/client/qbitorrentx"
 	"github.com/swkisdust/torrentremover/internal/client/transmissionx"
+	"github.com/swkisdust/torrentremover/internal/disk"
 	"github.com/swkisdust/torrentremover/internal/exprx"
 	logx "github.com/swkisdust/torrentremover/internal/log"
+	"github.com/swkisdust/torrentremover/internal/state"
 	"github.com/swkisdust/torrentremover/internal/utils"
 	"github.com/swkisdust/torrentremover/model"
 )
@@ -31,6 +33,14 @@ func initConfig(path string) (*model.Config, error) {
 		return nil, fmt.Errorf("init config: %v", err)
 	}
 
+	if config.StateFile == "" {
+		dir := filepath.Dir(path)
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			dir = path
+		}
+		config.StateFile = filepath.Join(dir, "state.json")
+	}
+
 	return &config, nil
 }
 
@@ -48,7 +58,7 @@ func main() {
 		Usage:   "torrentremover",
 		Version: model.Version,
 		Flags: []cli.Flag{
-			&cli.StringFlag{Name: "config", Aliases: []string{"c"}, Usage: "config path"},
+			&cli.StringFlag{Name: "config", Aliases: []string{"c"}, Usage: "config file, or a directory of yaml files to merge"},
 			&cli.BoolFlag{Name: "dry-run", Aliases: []string{"n"}, Usage: "dry run"},
 		},
 		Action: func(ctx context.Context, c *cli.Command) error {
@@ -81,7 +91,7 @@ func setupLogger(c model.LogConfig) {
 }
 
 func setupDaemon(ctx context.Context, c *model.Config, dryRun bool) error {
-	clientMap := parseClients(ctx, c)
+	clientMap := parseClients(c)
 	if len(clientMap) == 0 {
 		return errors.New("you didn't configure any client")
 	}
@@ -90,9 +100,27 @@ func setupDaemon(ctx context.Context, c *model.Config, dryRun bool) error {
 		return errors.New("you didn't configure any profile")
 	}
 
+	macros, err := exprx.ParseMacros(c.Macros)
+	if err != nil {
+		return fmt.Errorf("parse macros: %v", err)
+	}
+	scripts, err := compileStrategies(c, macros)
+	if err != nil {
+		return err
+	}
+
+	if c.Daemon.WaitClients > 0 {
+		waitClients(ctx, clientMap, time.Duration(c.Daemon.WaitClients))
+	}
+
+	store, err := state.Open(c.StateFile)
+	if err != nil {
+		return fmt.Errorf("open state file: %v", err)
+	}
+
 	if c.Daemon.Disabled {
 		slog.Info("running in oneshot mode")
-		return run(ctx, c, clientMap, dryRun)
+		return run(ctx, c, clientMap, store, scripts, dryRun)
 	}
 
 	slog.Info("running in daemon mode", "cronexp", c.Daemon.CronExp)
@@ -102,8 +130,8 @@ func setupDaemon(ctx context.Context, c *model.Config, dryRun bool) error {
 		cron.SkipIfStillRunning(cronLogger),
 	))
 
-	_, err := cronScheduler.AddFunc(c.Daemon.CronExp, func() {
-		if err := run(ctx, c, clientMap, dryRun); err != nil {
+	_, err = cronScheduler.AddFunc(c.Daemon.CronExp, func() {
+		if err := run(ctx, c, clientMap, store, scripts, dryRun); err != nil {
 			slog.Error("run() error", "error", err)
 		}
 	})
@@ -125,28 +153,28 @@ func setupDaemon(ctx context.Context, c *model.Config, dryRun bool) error {
 	return nil
 }
 
-func parseClients(ctx context.Context, c *model.Config) map[string]client.Client {
+func parseClients(c *model.Config) map[string]client.Client {
 	clientMap := make(map[string]client.Client)
 
 	for name, config := range c.Clients {
 		switch config.Type {
 		case "qbittorrent":
-			if client, err := qbitorrentx.NewQbittorrent(config.Config); err == nil {
-				clientMap[name] = client
+			if qb, err := qbitorrentx.NewQbittorrent(config.Config); err == nil {
+				clientMap[name] = client.Wrap(qb, qb.Options)
 			} else {
-				slog.Warn("failed to create qbittorrent client", "name", name, "config", config.Config, "error", err)
+				slog.Warn("failed to create qbittorrent client", "name", name, "error", err)
 			}
 		case "transmission":
-			if client, err := transmissionx.NewTransmission(config.Config); err == nil {
-				clientMap[name] = client
+			if tr, err := transmissionx.NewTransmission(config.Config); err == nil {
+				clientMap[name] = client.Wrap(tr, tr.Options)
 			} else {
-				slog.Warn("failed to create transmission client", "name", name, "config", config.Config, "error", err)
+				slog.Warn("failed to create transmission client", "name", name, "error", err)
 			}
 		case "deluge":
-			if client, err := delugex.NewDeluge(ctx, config.Config); err == nil {
-				clientMap[name] = client
+			if d, err := delugex.NewDeluge(config.Config); err == nil {
+				clientMap[name] = client.Wrap(d, d.Options)
 			} else {
-				slog.Warn("failed to create deluge client", "name", name, "config", config.Config, "error", err)
+				slog.Warn("failed to create deluge client", "name", name, "error", err)
 			}
 		default:
 			slog.Warn("unsupported client type", "client_name", name, "client_type", config.Type)
@@ -156,7 +184,66 @@ func parseClients(ctx context.Context, c *model.Config) map[string]client.Client
 	return clientMap
 }
 
-func run(ctx context.Context, c *model.Config, clientMap map[string]client.Client, dryRun bool) error {
+// waitClients waits for every client to respond, clients that don't are still
+// used since they connect on demand.
+func waitClients(ctx context.Context, clientMap map[string]client.Client, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for name, c := range clientMap {
+		slog.Info("waiting for client", "client_id", name)
+		if err := client.WaitReady(ctx, c); err != nil {
+			slog.Warn("client is not available", "client_id", name, "error", err)
+			continue
+		}
+		slog.Info("client is available", "client_id", name)
+	}
+}
+
+// compileStrategies compiles the exprs of every strategy into the config, and returns
+// the compiled scripts by strategy, so errors surface at startup instead of on every run.
+func compileStrategies(c *model.Config, macros *exprx.Macros) (map[*model.Strategy]*exprx.Script, error) {
+	scripts := make(map[*model.Strategy]*exprx.Script)
+	for i := range c.Profiles {
+		for j := range c.Profiles[i].Strategy {
+			st := &c.Profiles[i].Strategy[j]
+
+			// without expr every torrent left by the filters is acted on
+			prog, err := exprx.Compile(utils.IfOr(st.RemoveExpr == "", "torrents", st.RemoveExpr), nil, macros)
+			if err != nil {
+				return nil, fmt.Errorf("strategy %s: compile expr: %w", st.Name, err)
+			}
+			st.Prog = prog
+
+			if st.Match != "" {
+				if st.MatchProg, err = exprx.CompileMatch(st.Match, macros); err != nil {
+					return nil, fmt.Errorf("strategy %s: compile match expr: %w", st.Name, err)
+				}
+			}
+			if st.ScoreExpr != "" {
+				if st.ScoreProg, err = exprx.CompileScore(st.ScoreExpr, macros); err != nil {
+					return nil, fmt.Errorf("strategy %s: compile score expr: %w", st.Name, err)
+				}
+			}
+			if st.Script != "" {
+				if scripts[st], err = exprx.CompileScript(st.Name, st.Script, time.Duration(st.ScriptTimeout), st.ScriptMaxSteps); err != nil {
+					return nil, fmt.Errorf("strategy %s: compile script: %w", st.Name, err)
+				}
+			}
+		}
+	}
+	return scripts, nil
+}
+
+func run(ctx context.Context, c *model.Config, clientMap map[string]client.Client, store *state.Store, scripts map[*model.Strategy]*exprx.Script, dryRun bool) error {
+	var index *model.TorrentIndex
+	if usesCrossSeed(c) {
+		var err error
+		if index, err = buildIndex(ctx, clientMap); err != nil {
+			slog.Error("failed to build cross-seed index, skipping cross-seed strategies", "error", err)
+		}
+	}
+
 	for _, profile := range c.Profiles {
 		client, ok := clientMap[profile.Client]
 		if !ok {
@@ -170,17 +257,22 @@ func run(ctx context.Context, c *model.Config, clientMap map[string]client.Clien
 			continue
 		}
 		slog.Debug("available torrents", "value", torrents)
-		for _, st := range profile.Strategy {
-			if st.Prog == nil {
-				prog, err := exprx.Compile(st.RemoveExpr, client)
-				if err != nil {
-					slog.Error("failed to compile expr", "strategy", st.Name, "client_id", profile.Client, "error", err)
+
+		for j := range profile.Strategy {
+			st := &profile.Strategy[j]
+			// a Local per strategy, so the free space cached by one doesn't hide what it freed from the next
+			var local *disk.Local
+			if profile.FreeSpaceSource == "local" || profile.FreeSpaceSource == "auto" {
+				local = disk.NewLocal(profile.PathMap, profile.FreeSpaceSource == "auto")
+			}
+			var crossSeed *exprx.CrossSeed
+			if st.CrossSeed != "" {
+				if index == nil {
 					continue
 				}
-				st.Prog = prog
+				crossSeed = &exprx.CrossSeed{Mode: st.CrossSeed, Client: profile.Client, Clients: clientMap, Index: index}
 			}
-
-			freeSpace, err := client.GetFreeSpaceOnDisk(ctx, utils.IfOr(st.Mountpath != "", st.Mountpath, profile.Mountpath))
+			freeSpace, err := getFreeSpace(ctx, client, local, utils.IfOr(st.Mountpath != "", st.Mountpath, profile.Mountpath))
 			if err != nil {
 				slog.Warn("failed to get free space on disk", "strategy", st.Name, "client_id", profile.Client, "error", err)
 			}
@@ -188,28 +280,159 @@ func run(ctx context.Context, c *model.Config, clientMap map[string]client.Clien
 			if err != nil {
 				slog.Warn("failed to get session stats", "strategy", st.Name, "client_id", profile.Client, "error", err)
 			}
-			filteredTorrents := model.FilterTorrents(&st.Filter, freeSpace, torrents)
-			if len(filteredTorrents) < 1 {
-				slog.Debug("no matching torrents found", "strategy", st.Name)
-				continue
+			var filteredTorrents []*model.Torrent
+			var diskFree func(t *model.Torrent) int64
+			if local != nil {
+				torrentFree := torrentFreeSpace(local, freeSpace)
+				filteredTorrents = model.FilterTorrentsFunc(&st.Filter, torrentFree, torrents)
+				diskFree = func(t *model.Torrent) int64 { return int64(torrentFree(t)) }
+			} else {
+				filteredTorrents = model.FilterTorrents(&st.Filter, freeSpace, torrents)
+			}
+
+			disks := []diskGroup{{free: freeSpace, torrents: filteredTorrents}}
+			if st.PerDisk && local != nil {
+				disks = groupByDisk(local, freeSpace, torrents, filteredTorrents)
+			}
+			for _, d := range disks {
+				name := utils.IfOr(d.mount != "", st.Name+"@"+d.mount, st.Name)
+				var bucket *state.Bucket
+				if len(st.Actions) > 0 || !st.GracePeriod.IsZero() {
+					bucket = store.Bucket(profile.Client + "/" + name)
+				}
+
+				if len(d.torrents) < 1 {
+					if bucket != nil {
+						bucket.Track(nil, time.Now(), 0)
+					}
+					slog.Debug("no matching torrents found", "strategy", name)
+					continue
+				}
+
+				expr := exprx.New(st.Prog, client)
+				if err := expr.Run(ctx, d.torrents, name, exprx.RunOptions{
+					DryRun:       dryRun,
+					Reannounce:   profile.Reannounce || st.Reannounce,
+					DeleteFiles:  profile.DeleteFiles || st.DeleteFiles,
+					Interval:     utils.IfOr(st.DeleteDelay != 0, time.Duration(st.DeleteDelay)*time.Second, time.Duration(profile.DeleteDelay)*time.Second),
+					Disk:         int64(d.free),
+					Limits:       st.Limits(),
+					WantSpace:    int64(st.Filter.Disk),
+					Action:       st.Action,
+					AddTags:      st.AddTags,
+					Steps:        st.Actions,
+					GracePeriod:  st.GracePeriod,
+					State:        bucket,
+					SessionStats: stats,
+					AllTorrents:  torrents,
+					Match:        st.MatchProg,
+					Script:       scripts[st],
+					Score:        st.ScoreProg,
+					ScoreLimit:   st.ScoreLimit,
+
+					DiskFree:        diskFree,
+					CrossSeed:       crossSeed,
+					TrackerMessages: c.TrackerMessages,
+				}); err != nil {
+					slog.Error("failed to execute expr", "strategy", name, "client_id", profile.Client, "error", err)
+				}
 			}
+		}
+	}
+
+	if dryRun {
+		return nil
+	}
+	return store.Save()
+}
 
-			expr := exprx.New(st.Prog, client)
-			if err := expr.Run(ctx, filteredTorrents, st.Name, exprx.RunOptions{
-				DryRun:       dryRun,
-				Reannounce:   profile.Reannounce || st.Reannounce,
-				DeleteFiles:  profile.DeleteFiles || st.DeleteFiles,
-				Interval:     utils.IfOr(st.DeleteDelay != 0, time.Duration(st.DeleteDelay)*time.Second, time.Duration(profile.DeleteDelay)*time.Second),
-				Disk:         int64(freeSpace),
-				Limit:        st.Limit,
-				WantSpace:    int64(st.Filter.Disk),
-				Action:       st.Action,
-				SessionStats: stats,
-			}); err != nil {
-				slog.Error("failed to execute expr", "strategy", st.Name, "client_id", profile.Client, "error", err)
+func usesCrossSeed(c *model.Config) bool {
+	for _, profile := range c.Profiles {
+		for _, st := range profile.Strategy {
+			if st.CrossSeed != "" {
+				return true
 			}
 		}
 	}
+	return false
+}
 
-	return nil
+// buildIndex indexes the torrents of every client, it fails if any client can't be
+// reached since a missing client could still be seeding the files about to be deleted.
+func buildIndex(ctx context.Context, clientMap map[string]client.Client) (*model.TorrentIndex, error) {
+	torrents := make(map[string][]*model.Torrent, len(clientMap))
+	for name, client := range clientMap {
+		ts, err := client.GetTorrents(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("client %s: %w", name, err)
+		}
+		torrents[name] = ts
+	}
+	return model.NewIndex(torrents), nil
+}
+
+// getFreeSpace checks the disk locally if possible and asks the client otherwise.
+func getFreeSpace(ctx context.Context, c client.Client, local *disk.Local, path string) (model.Bytes, error) {
+	if local != nil && path != "" {
+		_, free, err := local.FreeSpace(path)
+		if err == nil {
+			return model.Bytes(free), nil
+		}
+		if !errors.Is(err, disk.ErrNotLocal) {
+			return -1, err
+		}
+	}
+	return c.GetFreeSpaceOnDisk(ctx, path)
+}
+
+// torrentFreeSpace returns the free space of the disk each torrent is saved on,
+// falling back to the strategy's free space if the disk can't be checked.
+func torrentFreeSpace(local *disk.Local, fallback model.Bytes) func(t *model.Torrent) model.Bytes {
+	return func(t *model.Torrent) model.Bytes {
+		_, free, err := local.FreeSpace(t.SavePath)
+		if err != nil {
+			if !errors.Is(err, disk.ErrNotLocal) {
+				slog.Warn("failed to get free space of torrent disk", "hash", t.Hash, "save_path", t.SavePath, "error", err)
+			}
+			return fallback
+		}
+		return model.Bytes(free)
+	}
+}
+
+// diskGroup holds the filtered torrents saved on one mount, mount is empty
+// for the torrents whose disk can't be checked locally.
+type diskGroup struct {
+	mount    string
+	free     model.Bytes
+	torrents []*model.Torrent
+}
+
+// groupByDisk groups the filtered torrents by mount, every mount any torrent is saved on
+// gets a group, even an empty one, so the state of disks that no longer match is cleared.
+func groupByDisk(local *disk.Local, fallback model.Bytes, torrents, filtered []*model.Torrent) []diskGroup {
+	var groups []diskGroup
+	index := make(map[string]int)
+	add := func(t *model.Torrent) int {
+		mount, free, err := local.FreeSpace(t.SavePath)
+		if err != nil {
+			mount, free = "", int64(fallback)
+		}
+		i, ok := index[mount]
+		if !ok {
+			i = len(groups)
+			index[mount] = i
+			groups = append(groups, diskGroup{mount: mount, free: model.Bytes(free)})
+		}
+		return i
+	}
+
+	for _, t := range torrents {
+		add(t)
+	}
+	for _, t := range filtered {
+		i := add(t)
+		groups[i].torrents = append(groups[i].torrents, t)
+	}
+	return groups
 }
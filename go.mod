@@ -6,6 +6,7 @@ require (
 	github.com/autobrr/go-deluge v1.3.1-0.20250503123942-245951c90584
 	github.com/autobrr/go-qbittorrent v1.14.0
 	github.com/expr-lang/expr v1.17.6
+	github.com/gdm85/go-rencode v0.1.8
 	github.com/go-viper/mapstructure/v2 v2.4.0
 	github.com/goccy/go-yaml v1.18.0
 	github.com/hekmon/transmissionrpc/v3 v3.0.0
@@ -14,16 +15,16 @@ require (
 	github.com/mattn/go-isatty v0.0.20
 	github.com/robfig/cron/v3 v3.0.1
 	github.com/urfave/cli/v3 v3.4.1
+	go.starlark.net v0.0.0-20250417143717-f57e51f710eb
 	golang.org/x/exp v0.0.0-20251002181428-27f1f14c8bb9
+	golang.org/x/sys v0.36.0
 )
 
 require (
 	github.com/Masterminds/semver v1.5.0 // indirect
 	github.com/avast/retry-go v3.0.0+incompatible // indirect
-	github.com/gdm85/go-rencode v0.1.8 // indirect
 	github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
 	github.com/hekmon/cunits/v2 v2.1.1 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	golang.org/x/net v0.44.0 // indirect
-	golang.org/x/sys v0.36.0 // indirect
 )
@@ -11,8 +11,13 @@ type Client interface {
 	GetTorrents(ctx context.Context) ([]*model.Torrent, error)
 	PauseTorrents(ctx context.Context, torrents []*model.Torrent) error
 	ResumeTorrents(ctx context.Context, torrents []*model.Torrent) error
-	ThrottleTorrents(ctx context.Context, torrents []*model.Torrent, limit model.Bytes) error
+	RecheckTorrents(ctx context.Context, torrents []*model.Torrent) error
+	ForceStartTorrents(ctx context.Context, torrents []*model.Torrent) error
+	ReannounceTorrents(ctx context.Context, torrents []*model.Torrent) error
+	TagTorrents(ctx context.Context, torrents []*model.Torrent, tags []string) error
+	ThrottleTorrents(ctx context.Context, torrents []*model.Torrent, limits model.Limits) error
 	DeleteTorrents(ctx context.Context, torrents []*model.Torrent, name string, reannounce, deleteFiles bool, interval time.Duration) error
 	GetFreeSpaceOnDisk(ctx context.Context, path string) (model.Bytes, error)
 	SessionStats(ctx context.Context) (model.SessionStats, error)
+	Ping(ctx context.Context) error // checks that the client is reachable and logged in
 }
@@ -0,0 +1,86 @@
+package delugex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+
+	"github.com/autobrr/go-deluge"
+
+	"github.com/swkisdust/torrentremover/internal/client"
+)
+
+// managed keeps a connection open between calls, it's dialed on first use
+// and again after it drops.
+type managed[C interface {
+	comparable
+	io.Closer
+}] struct {
+	host string
+	dial func(ctx context.Context) (C, error)
+
+	mu   sync.Mutex
+	conn C
+	open bool
+}
+
+// get returns the current connection or dials a new one, retrying with backoff.
+func (m *managed[C]) get(ctx context.Context) (C, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.open {
+		return m.conn, nil
+	}
+
+	var conn C
+	err := client.DefaultBackoff.Retry(ctx, func(ctx context.Context) (err error) {
+		conn, err = m.dial(ctx)
+		return err
+	})
+	if err != nil {
+		return conn, fmt.Errorf("connect to deluge: %w", err)
+	}
+
+	m.conn, m.open = conn, true
+	return conn, nil
+}
+
+// reset drops a broken connection so the next call reconnects.
+func (m *managed[C]) reset(conn C) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.open && m.conn == conn {
+		_ = conn.Close()
+		var zero C
+		m.conn, m.open = zero, false
+	}
+}
+
+// do runs fn on the connection, reconnecting and running it once more if the connection dropped.
+func (m *managed[C]) do(ctx context.Context, fn func(conn C) error) error {
+	for attempt := 0; ; attempt++ {
+		conn, err := m.get(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = fn(conn)
+		if err == nil || !isConnError(err) || attempt > 0 {
+			return err
+		}
+		slog.Warn("deluge connection lost, reconnecting", "host", m.host, "error", err)
+		m.reset(conn)
+	}
+}
+
+func isConnError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) || errors.Is(err, deluge.ErrAlreadyClosed)
+}
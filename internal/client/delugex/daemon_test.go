@@ -0,0 +1,182 @@
+package delugex
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gdm85/go-rencode"
+)
+
+// rpcCall is a call received by fakeDaemon.
+type rpcCall struct {
+	method string
+	args   []any
+}
+
+// fakeDaemon speaks the deluge v2 RPC protocol, it answers daemon.login
+// and replies to the other methods with replies[method] (None if unset).
+type fakeDaemon struct {
+	t        *testing.T
+	listener net.Listener
+	replies  map[string]any
+
+	mu    sync.Mutex
+	calls []rpcCall
+	// dropAfter closes a connection after that many calls (including login), 0 never does
+	dropAfter int
+	conns     int
+}
+
+func newFakeDaemon(t *testing.T, replies map[string]any) *fakeDaemon {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &fakeDaemon{t: t, listener: listener, replies: replies}
+	go d.serve()
+	t.Cleanup(func() { listener.Close() })
+	return d
+}
+
+func (d *fakeDaemon) addr() string {
+	return d.listener.Addr().String()
+}
+
+func (d *fakeDaemon) config() map[string]any {
+	return map[string]any{"host": d.addr(), "username": "user", "password": "pass", "v2": true, "timeout": "2s"}
+}
+
+func (d *fakeDaemon) connections() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.conns
+}
+
+// methods returns the methods called so far, without logins.
+func (d *fakeDaemon) methods() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var methods []string
+	for _, c := range d.calls {
+		if c.method != "daemon.login" {
+			methods = append(methods, c.method)
+		}
+	}
+	return methods
+}
+
+func (d *fakeDaemon) lastCall(method string) (rpcCall, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i := len(d.calls) - 1; i >= 0; i-- {
+		if d.calls[i].method == method {
+			return d.calls[i], true
+		}
+	}
+	return rpcCall{}, false
+}
+
+func (d *fakeDaemon) serve() {
+	for {
+		conn, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+		d.mu.Lock()
+		d.conns++
+		d.mu.Unlock()
+		go d.handle(conn)
+	}
+}
+
+func (d *fakeDaemon) handle(conn net.Conn) {
+	defer conn.Close()
+
+	for n := 1; ; n++ {
+		var header [5]byte
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header[1:]))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			d.t.Errorf("fake daemon: %v", err)
+			return
+		}
+		var req rencode.List
+		dec := rencode.NewDecoder(zr)
+		if err := dec.Scan(&req); err != nil {
+			d.t.Errorf("fake daemon: %v", err)
+			return
+		}
+		first := req.Values()[0].(rencode.List)
+		call := first.Values()
+		serial := call[0]
+		method, _ := toString(call[1])
+		argList := call[2].(rencode.List)
+		args := argList.Values()
+
+		d.mu.Lock()
+		d.calls = append(d.calls, rpcCall{method, args})
+		drop := d.dropAfter > 0 && n > d.dropAfter
+		d.mu.Unlock()
+		if drop {
+			return
+		}
+
+		var reply any
+		if method == "daemon.login" {
+			reply = int64(10)
+		} else {
+			reply = d.replies[method]
+		}
+
+		var resp bytes.Buffer
+		zw := zlib.NewWriter(&resp)
+		enc := rencode.NewEncoder(zw)
+		if err := enc.Encode(rencode.NewList(int64(rpcResponse), serial, reply)); err != nil {
+			d.t.Errorf("fake daemon: %v", err)
+			return
+		}
+		zw.Close()
+
+		header[0] = protocolVersion
+		binary.BigEndian.PutUint32(header[1:], uint32(resp.Len()))
+		if _, err := conn.Write(append(header[:], resp.Bytes()...)); err != nil {
+			return
+		}
+	}
+}
@@ -12,39 +12,33 @@ import (
 	"time"
 
 	"github.com/autobrr/go-deluge"
-	"github.com/go-viper/mapstructure/v2"
+	"github.com/gdm85/go-rencode"
 
+	"github.com/swkisdust/torrentremover/internal/client"
 	"github.com/swkisdust/torrentremover/internal/utils"
 	"github.com/swkisdust/torrentremover/model"
 )
 
-type delugeClient interface {
-	deluge.DelugeClient
-	LabelPlugin(ctx context.Context) (*deluge.LabelPlugin, error)
-}
-
 type Deluge struct {
-	Host     string `mapstructure:"host"`
-	Username string `mapstructure:"username"`
-	Password string `mapstructure:"password"`
-	V2       bool   `mapstructure:"v2"`
+	Host        string `mapstructure:"host"`
+	Username    string `mapstructure:"username"`
+	Password    string `mapstructure:"password"`
+	V2          bool   `mapstructure:"v2"`
+	LabelPlugin string `mapstructure:"label_plugin"` // label, labelplus or empty to use whichever is set
+
+	client.Options `mapstructure:",squash"`
 
-	lp     *deluge.LabelPlugin
-	client delugeClient
+	client *managed[deluge.DelugeClient]
+	rpc    *managed[*rpcConn]
 }
 
-func NewDeluge(ctx context.Context, config map[string]any) (*Deluge, error) {
+// NewDeluge doesn't connect, the connection is made on first use.
+func NewDeluge(config map[string]any) (*Deluge, error) {
 	var d Deluge
-	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
-		WeaklyTypedInput:     true,
-		IgnoreUntaggedFields: true,
-		Result:               &d,
-	})
-	if err != nil {
+	if err := client.Decode(config, &d); err != nil {
 		return nil, err
 	}
-
-	if err := decoder.Decode(config); err != nil {
+	if err := d.Options.Validate(); err != nil {
 		return nil, err
 	}
 
@@ -63,56 +57,136 @@ func NewDeluge(ctx context.Context, config map[string]any) (*Deluge, error) {
 		Port:             uint(portInt),
 		Login:            d.Username,
 		Password:         d.Password,
-		ReadWriteTimeout: time.Second * 5,
-	}
-
-	var client delugeClient
-	if !d.V2 {
-		client = deluge.NewV1(settings)
-	} else {
-		client = deluge.NewV2(settings)
+		ReadWriteTimeout: d.timeout(),
 	}
 
-	if lp, err := client.LabelPlugin(ctx); err == nil {
-		d.lp = lp
-	} else {
-		slog.Warn("failed to retrieve deluge label plugin", "error", err)
+	switch d.LabelPlugin {
+	case "", "label", "labelplus":
+	default:
+		return nil, fmt.Errorf("unsupported deluge label plugin %q", d.LabelPlugin)
 	}
 
-	if err := client.Connect(ctx); err != nil {
-		return nil, err
-	}
+	limiter := client.NewLimiter(d.Options)
+	d.client = &managed[deluge.DelugeClient]{host: d.Host, dial: func(ctx context.Context) (deluge.DelugeClient, error) {
+		var c deluge.DelugeClient
+		if !d.V2 {
+			c = deluge.NewV1(settings)
+		} else {
+			c = deluge.NewV2(settings)
+		}
+		if err := c.Connect(ctx); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+		if limiter != nil {
+			c = &limitedClient{c, limiter}
+		}
+		return c, nil
+	}}
+	d.rpc = &managed[*rpcConn]{host: d.Host, dial: func(ctx context.Context) (*rpcConn, error) {
+		return dialRPC(ctx, d.Host, d.Username, d.Password, d.V2, d.timeout(), limiter)
+	}}
 
 	return &d, nil
 }
 
+// timeout of every read and write on the connection, 5s unless configured.
+func (d *Deluge) timeout() time.Duration {
+	return utils.IfOr(d.Timeout > 0, d.Timeout, time.Second*5)
+}
+
+// do runs fn on the go-deluge connection.
+func (d *Deluge) do(ctx context.Context, fn func(c deluge.DelugeClient) error) error {
+	return d.client.do(ctx, fn)
+}
+
+// doRPC runs fn on the connection used for calls go-deluge doesn't have.
+func (d *Deluge) doRPC(ctx context.Context, fn func(c *rpcConn) error) error {
+	return d.rpc.do(ctx, fn)
+}
+
 func (d *Deluge) GetTorrents(ctx context.Context) ([]*model.Torrent, error) {
-	torrents, err := d.client.TorrentsStatus(ctx, "", nil)
-	if err != nil {
+	var torrents map[string]*deluge.TorrentStatus
+	if err := d.do(ctx, func(c deluge.DelugeClient) (err error) {
+		torrents, err = c.TorrentsStatus(ctx, "", nil)
+		return err
+	}); err != nil {
 		return nil, err
 	}
 
-	var labels map[string]string
-	if d.lp != nil {
-		if labels, err = d.lp.GetTorrentsLabels(deluge.StateUnspecified, nil); err != nil {
-			return nil, err
-		}
-	} else {
-		labels = make(map[string]string)
+	// without the extra fields labels, trackers and activity would be empty,
+	// which filters like excluded_categories can't tell apart from real values
+	extras, err := d.torrentsExtra(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get extra torrent fields: %w", err)
 	}
 
 	return slices.Collect(utils.Seq2To1(maps.All(torrents),
 		func(id string, ds *deluge.TorrentStatus) *model.Torrent {
-			return model.FromDeluge(ds, labels[id])
+			extra, ok := extras[id]
+			if !ok {
+				extra.TimeSinceTransfer = -1
+			}
+			return model.FromDeluge(ds, extra)
 		})), nil
 }
 
+// torrentsExtra fetches trackers, last transfer time and labels over the rpc connection.
+func (d *Deluge) torrentsExtra(ctx context.Context) (map[string]model.DelugeExtra, error) {
+	var statuses map[string]map[string]any
+	if err := d.doRPC(ctx, func(c *rpcConn) (err error) {
+		statuses, err = c.torrentsStatus(ctx, "trackers", "time_since_transfer", "label", "labelplus_name")
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	extras := make(map[string]model.DelugeExtra, len(statuses))
+	for id, status := range statuses {
+		extra := model.DelugeExtra{TimeSinceTransfer: -1}
+		if secs, ok := toInt64(status["time_since_transfer"]); ok {
+			extra.TimeSinceTransfer = secs
+		}
+
+		label, _ := toString(status["label"])
+		labelPlus, _ := toString(status["labelplus_name"])
+		switch d.LabelPlugin {
+		case "label":
+			extra.Label = label
+		case "labelplus":
+			extra.Label = labelPlus
+		default:
+			extra.Label = utils.IfOr(label != "", label, labelPlus)
+		}
+
+		if trackers, ok := status["trackers"].(rencode.List); ok {
+			for _, v := range trackers.Values() {
+				tracker, ok := v.(rencode.Dictionary)
+				if !ok {
+					continue
+				}
+				if url, ok := tracker.Get("url"); ok {
+					if url, ok := toString(url); ok {
+						extra.Trackers = append(extra.Trackers, url)
+					}
+				}
+			}
+		}
+
+		extras[id] = extra
+	}
+
+	return extras, nil
+}
+
 func (d *Deluge) PauseTorrents(ctx context.Context, torrents []*model.Torrent) error {
 	hashes := utils.SlicesMap(torrents, func(t *model.Torrent) string {
 		return t.Hash
 	})
 
-	return d.client.PauseTorrents(ctx, hashes...)
+	return d.do(ctx, func(c deluge.DelugeClient) error {
+		return c.PauseTorrents(ctx, hashes...)
+	})
 }
 
 func (d *Deluge) ResumeTorrents(ctx context.Context, torrents []*model.Torrent) error {
@@ -120,34 +194,111 @@ func (d *Deluge) ResumeTorrents(ctx context.Context, torrents []*model.Torrent)
 		return t.Hash
 	})
 
-	return d.client.ResumeTorrents(ctx, hashes...)
+	return d.do(ctx, func(c deluge.DelugeClient) error {
+		return c.ResumeTorrents(ctx, hashes...)
+	})
 }
 
-func (d *Deluge) ThrottleTorrents(ctx context.Context, torrents []*model.Torrent, limit model.Bytes) error {
+// RecheckTorrents calls core.force_recheck over the rpc connection, go-deluge doesn't expose it.
+func (d *Deluge) RecheckTorrents(ctx context.Context, torrents []*model.Torrent) error {
 	hashes := utils.SlicesMap(torrents, func(t *model.Torrent) string {
 		return t.Hash
 	})
 
-	var uploadSpeed int
-	if limit == -1 {
-		uploadSpeed = -1
-	} else {
-		uploadSpeed = int(limit.KiB())
-	}
+	return d.doRPC(ctx, func(c *rpcConn) error {
+		return c.forceRecheck(ctx, hashes)
+	})
+}
+
+// ForceStartTorrents takes torrents out of the queue manager and resumes them,
+// which is the closest deluge has to a force start.
+func (d *Deluge) ForceStartTorrents(ctx context.Context, torrents []*model.Torrent) error {
+	hashes := utils.SlicesMap(torrents, func(t *model.Torrent) string {
+		return t.Hash
+	})
 
+	autoManaged := false
 	opts := deluge.Options{
-		MaxUploadSpeed: &uploadSpeed,
+		AutoManaged: &autoManaged,
 	}
 
-	var wrapErr error
-	for _, id := range hashes {
-		if err := d.client.SetTorrentOptions(ctx, id, &opts); err != nil {
-			wrapErr = errors.Join(wrapErr, err)
-			continue
+	return d.do(ctx, func(c deluge.DelugeClient) error {
+		var wrapErr error
+		for _, id := range hashes {
+			if err := c.SetTorrentOptions(ctx, id, &opts); err != nil {
+				wrapErr = errors.Join(wrapErr, err)
+				continue
+			}
+		}
+		if wrapErr != nil {
+			return wrapErr
 		}
+
+		return c.ResumeTorrents(ctx, hashes...)
+	})
+}
+
+func (d *Deluge) ReannounceTorrents(ctx context.Context, torrents []*model.Torrent) error {
+	hashes := utils.SlicesMap(torrents, func(t *model.Torrent) string {
+		return t.Hash
+	})
+
+	return d.do(ctx, func(c deluge.DelugeClient) error {
+		return c.ForceReannounce(ctx, hashes)
+	})
+}
+
+// TagTorrents is not supported, deluge has no tags besides the single label.
+func (d *Deluge) TagTorrents(ctx context.Context, torrents []*model.Torrent, tags []string) error {
+	return fmt.Errorf("deluge: tag torrents: %w", errors.ErrUnsupported)
+}
+
+func (d *Deluge) ThrottleTorrents(ctx context.Context, torrents []*model.Torrent, limits model.Limits) error {
+	hashes := utils.SlicesMap(torrents, func(t *model.Torrent) string {
+		return t.Hash
+	})
+
+	opts := limitsOptions(limits)
+	return d.do(ctx, func(c deluge.DelugeClient) error {
+		var wrapErr error
+		for _, id := range hashes {
+			if err := c.SetTorrentOptions(ctx, id, &opts); err != nil {
+				wrapErr = errors.Join(wrapErr, err)
+				continue
+			}
+		}
+		return wrapErr
+	})
+}
+
+func limitsOptions(limits model.Limits) deluge.Options {
+	var opts deluge.Options
+	if limits.Upload != 0 {
+		uploadSpeed := utils.IfOr(limits.Upload < 0, -1, int(limits.Upload.KiB()))
+		opts.MaxUploadSpeed = &uploadSpeed
+	}
+	if limits.Download != 0 {
+		downloadSpeed := utils.IfOr(limits.Download < 0, -1, int(limits.Download.KiB()))
+		opts.MaxDownloadSpeed = &downloadSpeed
+	}
+	// deluge only copies its global ratio to torrents when they are added,
+	// so restoring the global share limits removes the torrent's one
+	if limits.Ratio != 0 || limits.GlobalShare {
+		stopAtRatio := limits.Ratio > 0 && !limits.GlobalShare
+		opts.StopAtRatio = &stopAtRatio
+		if stopAtRatio {
+			ratio := float32(limits.Ratio)
+			opts.StopRatio = &ratio
+		}
+	}
+	if limits.SeedingTime > 0 {
+		slog.Warn("deluge does not support per-torrent seeding time limits, ignored", "limit", limits.SeedingTime)
+	}
+	if limits.Group != nil && *limits.Group != "" {
+		slog.Warn("deluge does not support bandwidth groups, ignored", "group", *limits.Group)
 	}
 
-	return wrapErr
+	return opts
 }
 
 func (d *Deluge) DeleteTorrents(ctx context.Context, torrents []*model.Torrent, name string, reannounce, deleteFiles bool, interval time.Duration) error {
@@ -157,7 +308,7 @@ func (d *Deluge) DeleteTorrents(ctx context.Context, torrents []*model.Torrent,
 
 	if reannounce {
 		slog.Debug("pausing torrents", "strategy", name)
-		if err := d.client.PauseTorrents(ctx, hashes...); err != nil {
+		if err := d.do(ctx, func(c deluge.DelugeClient) error { return c.PauseTorrents(ctx, hashes...) }); err != nil {
 			return err
 		}
 
@@ -165,7 +316,7 @@ func (d *Deluge) DeleteTorrents(ctx context.Context, torrents []*model.Torrent,
 		time.Sleep(time.Second * 2)
 
 		slog.Debug("resuming torrents", "strategy", name)
-		if err := d.client.ResumeTorrents(ctx, hashes...); err != nil {
+		if err := d.do(ctx, func(c deluge.DelugeClient) error { return c.ResumeTorrents(ctx, hashes...) }); err != nil {
 			return err
 		}
 
@@ -173,7 +324,7 @@ func (d *Deluge) DeleteTorrents(ctx context.Context, torrents []*model.Torrent,
 		time.Sleep(time.Second * 2)
 
 		slog.Debug("reannouncing torrents", "strategy", name)
-		if err := d.client.ForceReannounce(ctx, hashes); err != nil {
+		if err := d.do(ctx, func(c deluge.DelugeClient) error { return c.ForceReannounce(ctx, hashes) }); err != nil {
 			return err
 		}
 
@@ -181,13 +332,18 @@ func (d *Deluge) DeleteTorrents(ctx context.Context, torrents []*model.Torrent,
 		time.Sleep(utils.IfOr(interval != 0, interval, time.Second*4))
 	}
 
-	_, err := d.client.RemoveTorrents(ctx, hashes, deleteFiles)
-	return err
+	return d.do(ctx, func(c deluge.DelugeClient) error {
+		_, err := c.RemoveTorrents(ctx, hashes, deleteFiles)
+		return err
+	})
 }
 
 func (d *Deluge) GetFreeSpaceOnDisk(ctx context.Context, path string) (model.Bytes, error) {
-	size, err := d.client.GetFreeSpace(ctx, path)
-	if err != nil {
+	var size int64
+	if err := d.do(ctx, func(c deluge.DelugeClient) (err error) {
+		size, err = c.GetFreeSpace(ctx, path)
+		return err
+	}); err != nil {
 		return -1, err
 	}
 	return model.Bytes(size), nil
@@ -195,8 +351,11 @@ func (d *Deluge) GetFreeSpaceOnDisk(ctx context.Context, path string) (model.Byt
 
 func (d *Deluge) SessionStats(ctx context.Context) (model.SessionStats, error) {
 	var stats model.SessionStats
-	dstats, err := d.client.GetSessionStatus(ctx)
-	if err != nil {
+	var dstats *deluge.SessionStatus
+	if err := d.do(ctx, func(c deluge.DelugeClient) (err error) {
+		dstats, err = c.GetSessionStatus(ctx)
+		return err
+	}); err != nil {
 		return stats, err
 	}
 
@@ -204,3 +363,10 @@ func (d *Deluge) SessionStats(ctx context.Context) (model.SessionStats, error) {
 	stats.TotalUpSpeed = int64(dstats.UploadRate)
 	return stats, nil
 }
+
+func (d *Deluge) Ping(ctx context.Context) error {
+	return d.do(ctx, func(c deluge.DelugeClient) error {
+		_, err := c.DaemonVersion(ctx)
+		return err
+	})
+}
@@ -0,0 +1,74 @@
+package delugex
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/gdm85/go-rencode"
+
+	"github.com/swkisdust/torrentremover/model"
+)
+
+func TestLimitsOptions(t *testing.T) {
+	opts := limitsOptions(model.NoLimits)
+	if *opts.MaxUploadSpeed != -1 || *opts.MaxDownloadSpeed != -1 {
+		t.Errorf("expected no limits to remove the speed limits, got %d and %d", *opts.MaxUploadSpeed, *opts.MaxDownloadSpeed)
+	}
+	if *opts.StopAtRatio || opts.StopRatio != nil {
+		t.Error("expected no limits to stop seeding at a ratio")
+	}
+
+	opts = limitsOptions(model.Limits{Ratio: 1.5, Upload: 2048})
+	if !*opts.StopAtRatio || *opts.StopRatio != 1.5 || *opts.MaxUploadSpeed != 2 {
+		t.Errorf("expected ratio 1.5 and a 2 KiB/s upload limit, got %v, %v and %d", *opts.StopAtRatio, *opts.StopRatio, *opts.MaxUploadSpeed)
+	}
+	if opts.MaxDownloadSpeed != nil {
+		t.Error("expected the download limit to be left untouched")
+	}
+}
+
+func TestTorrentActions(t *testing.T) {
+	torrents := []*model.Torrent{{Hash: "abc"}, {Hash: "def"}}
+
+	tests := []struct {
+		name    string
+		action  func(d *Deluge) error
+		methods []string
+	}{
+		{"recheck", func(d *Deluge) error {
+			return d.RecheckTorrents(context.Background(), torrents)
+		}, []string{"core.force_recheck"}},
+		{"force start", func(d *Deluge) error {
+			return d.ForceStartTorrents(context.Background(), torrents)
+		}, []string{"core.set_torrent_options", "core.set_torrent_options", "core.resume_torrents"}},
+		{"reannounce", func(d *Deluge) error {
+			return d.ReannounceTorrents(context.Background(), torrents)
+		}, []string{"core.force_reannounce"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			daemon := newFakeDaemon(t, nil)
+			d, err := NewDeluge(daemon.config())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tt.action(d); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if methods := daemon.methods(); !slices.Equal(methods, tt.methods) {
+				t.Errorf("called %v, want %v", methods, tt.methods)
+			}
+
+			last, _ := daemon.lastCall(tt.methods[len(tt.methods)-1])
+			hashes, ok := last.args[0].(rencode.List)
+			if !ok {
+				t.Fatalf("expected a list of hashes, got %T", last.args[0])
+			}
+			if got := toStrings(hashes.Values()); !slices.Equal(got, []string{"abc", "def"}) {
+				t.Errorf("sent hashes %v, want [abc def]", got)
+			}
+		})
+	}
+}
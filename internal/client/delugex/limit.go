@@ -0,0 +1,69 @@
+package delugex
+
+import (
+	"context"
+
+	"github.com/autobrr/go-deluge"
+
+	"github.com/swkisdust/torrentremover/internal/client"
+)
+
+// limitedClient applies the limiter to every go-deluge call the backend makes,
+// each of them is a single request to the daemon.
+type limitedClient struct {
+	deluge.DelugeClient
+	limiter *client.Limiter
+}
+
+func limit[T any](ctx context.Context, l *client.Limiter, fn func() (T, error)) (T, error) {
+	release, err := l.Acquire(ctx)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	defer release()
+	return fn()
+}
+
+func limitErr(ctx context.Context, l *client.Limiter, fn func() error) error {
+	_, err := limit(ctx, l, func() (struct{}, error) { return struct{}{}, fn() })
+	return err
+}
+
+func (c *limitedClient) DaemonVersion(ctx context.Context) (string, error) {
+	return limit(ctx, c.limiter, func() (string, error) { return c.DelugeClient.DaemonVersion(ctx) })
+}
+
+func (c *limitedClient) GetFreeSpace(ctx context.Context, path string) (int64, error) {
+	return limit(ctx, c.limiter, func() (int64, error) { return c.DelugeClient.GetFreeSpace(ctx, path) })
+}
+
+func (c *limitedClient) GetSessionStatus(ctx context.Context) (*deluge.SessionStatus, error) {
+	return limit(ctx, c.limiter, func() (*deluge.SessionStatus, error) { return c.DelugeClient.GetSessionStatus(ctx) })
+}
+
+func (c *limitedClient) TorrentsStatus(ctx context.Context, state deluge.TorrentState, ids []string) (map[string]*deluge.TorrentStatus, error) {
+	return limit(ctx, c.limiter, func() (map[string]*deluge.TorrentStatus, error) {
+		return c.DelugeClient.TorrentsStatus(ctx, state, ids)
+	})
+}
+
+func (c *limitedClient) RemoveTorrents(ctx context.Context, ids []string, rmFiles bool) ([]deluge.TorrentError, error) {
+	return limit(ctx, c.limiter, func() ([]deluge.TorrentError, error) { return c.DelugeClient.RemoveTorrents(ctx, ids, rmFiles) })
+}
+
+func (c *limitedClient) PauseTorrents(ctx context.Context, ids ...string) error {
+	return limitErr(ctx, c.limiter, func() error { return c.DelugeClient.PauseTorrents(ctx, ids...) })
+}
+
+func (c *limitedClient) ResumeTorrents(ctx context.Context, ids ...string) error {
+	return limitErr(ctx, c.limiter, func() error { return c.DelugeClient.ResumeTorrents(ctx, ids...) })
+}
+
+func (c *limitedClient) SetTorrentOptions(ctx context.Context, id string, options *deluge.Options) error {
+	return limitErr(ctx, c.limiter, func() error { return c.DelugeClient.SetTorrentOptions(ctx, id, options) })
+}
+
+func (c *limitedClient) ForceReannounce(ctx context.Context, ids []string) error {
+	return limitErr(ctx, c.limiter, func() error { return c.DelugeClient.ForceReannounce(ctx, ids) })
+}
@@ -0,0 +1,281 @@
+package delugex
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/gdm85/go-rencode"
+
+	"github.com/swkisdust/torrentremover/internal/client"
+)
+
+// go-deluge only requests a fixed set of torrent status keys, rpcConn is a minimal
+// deluge RPC connection used to fetch the rest (trackers, plugin fields and so on).
+
+const (
+	rpcResponse = 1
+	rpcError    = 2
+
+	protocolVersion = 1
+)
+
+type rpcConn struct {
+	conn    *tls.Conn
+	v2      bool
+	timeout time.Duration // of every call, unless the context ends earlier
+	limiter *client.Limiter
+	serial  int64
+}
+
+func dialRPC(ctx context.Context, addr, username, password string, v2 bool, timeout time.Duration, limiter *client.Limiter) (*rpcConn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	rawConn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &rpcConn{
+		conn: tls.Client(rawConn, &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: true, // deluge uses a self-signed certificate
+		}),
+		v2:      v2,
+		timeout: timeout,
+		limiter: limiter,
+	}
+
+	var kwargs rencode.Dictionary
+	if v2 {
+		kwargs.Add("client_version", "2.0.3")
+	}
+	if _, err := c.call(ctx, "daemon.login", rencode.NewList(username, password), kwargs); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("daemon.login: %w", err)
+	}
+
+	return c, nil
+}
+
+func (c *rpcConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *rpcConn) call(ctx context.Context, method string, args rencode.List, kwargs rencode.Dictionary) (any, error) {
+	release, err := c.limiter.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	c.serial++
+
+	deadline, ok := ctx.Deadline()
+	if c.timeout > 0 && (!ok || time.Until(deadline) > c.timeout) {
+		deadline = time.Now().Add(c.timeout)
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	var req bytes.Buffer
+	zw := zlib.NewWriter(&req)
+	enc := rencode.NewEncoder(zw)
+	if err := enc.Encode(rencode.NewList(rencode.NewList(c.serial, method, args, kwargs))); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	if c.v2 {
+		var header [5]byte
+		header[0] = protocolVersion
+		binary.BigEndian.PutUint32(header[1:], uint32(req.Len()))
+		if _, err := c.conn.Write(header[:]); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := c.conn.Write(req.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var src io.Reader = c.conn
+	if c.v2 {
+		var header [5]byte
+		if _, err := io.ReadFull(c.conn, header[:]); err != nil {
+			return nil, err
+		}
+		if header[0] != protocolVersion {
+			return nil, fmt.Errorf("unexpected protocol version %d", header[0])
+		}
+
+		body := make([]byte, binary.BigEndian.Uint32(header[1:]))
+		if _, err := io.ReadFull(c.conn, body); err != nil {
+			return nil, err
+		}
+		src = bytes.NewReader(body)
+	}
+
+	zr, err := zlib.NewReader(src)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var resp rencode.List
+	if err := rencode.NewDecoder(zr).Scan(&resp); err != nil {
+		return nil, err
+	}
+
+	values := resp.Values()
+	if len(values) < 3 {
+		return nil, fmt.Errorf("malformed response to %s: %v", method, values)
+	}
+	if serial, ok := toInt64(values[1]); !ok || serial != c.serial {
+		return nil, fmt.Errorf("serial mismatch in response to %s: expected %d, got %v", method, c.serial, values[1])
+	}
+
+	switch msgType, _ := toInt64(values[0]); msgType {
+	case rpcResponse:
+		return values[2], nil
+	case rpcError:
+		return nil, rpcErr(values[2:])
+	default:
+		return nil, fmt.Errorf("unexpected message type %d in response to %s", msgType, method)
+	}
+}
+
+// forceRecheck calls core.force_recheck for the torrents.
+func (c *rpcConn) forceRecheck(ctx context.Context, hashes []string) error {
+	args := rencode.NewList(rencode.NewList(toAnySlice(hashes)...))
+	_, err := c.call(ctx, "core.force_recheck", args, rencode.Dictionary{})
+	return err
+}
+
+// torrentsStatus calls core.get_torrents_status for all torrents with arbitrary keys.
+func (c *rpcConn) torrentsStatus(ctx context.Context, keys ...string) (map[string]map[string]any, error) {
+	var filter rencode.Dictionary
+	args := rencode.NewList(filter, rencode.NewList(toAnySlice(keys)...))
+
+	v, err := c.call(ctx, "core.get_torrents_status", args, rencode.Dictionary{})
+	if err != nil {
+		return nil, err
+	}
+
+	rd, ok := v.(rencode.Dictionary)
+	if !ok {
+		return nil, fmt.Errorf("core.get_torrents_status returned %T, expected a dictionary", v)
+	}
+
+	torrents, err := rd.Zip()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]map[string]any, len(torrents))
+	for hash, v := range torrents {
+		d, ok := v.(rencode.Dictionary)
+		if !ok {
+			return nil, fmt.Errorf("status of %s is %T, expected a dictionary", hash, v)
+		}
+		if result[hash], err = d.Zip(); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// rpcErr formats deluge's error reply, which differs between v1 and v2
+// but always starts with the exception type.
+func rpcErr(values []any) error {
+	if len(values) == 1 {
+		if l, ok := values[0].(rencode.List); ok {
+			values = l.Values()
+		}
+	}
+
+	parts := make([]any, 0, 2)
+	for _, v := range values[:min(len(values), 2)] {
+		switch v := v.(type) {
+		case []byte:
+			parts = append(parts, string(v))
+		case rencode.List:
+			parts = append(parts, toStrings(v.Values()))
+		default:
+			parts = append(parts, v)
+		}
+	}
+	return errors.New(fmt.Sprint(parts...))
+}
+
+func toAnySlice[E any](s []E) []any {
+	result := make([]any, len(s))
+	for i := range s {
+		result[i] = s[i]
+	}
+	return result
+}
+
+func toStrings(values []any) []string {
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := toString(v); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func toString(v any) (string, bool) {
+	switch v := v.(type) {
+	case []byte:
+		return string(v), true
+	case string:
+		return v, true
+	default:
+		return "", false
+	}
+}
+
+func toInt64(v any) (int64, bool) {
+	switch v := v.(type) {
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	case float32:
+		return int64(v), true
+	case float64:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,92 @@
+package delugex
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gdm85/go-rencode"
+
+	"github.com/swkisdust/torrentremover/model"
+)
+
+func torrentsStatusReply() rencode.Dictionary {
+	var tracker rencode.Dictionary
+	tracker.Add("url", "https://tracker.example/announce")
+
+	var status rencode.Dictionary
+	status.Add("trackers", rencode.NewList(tracker))
+	status.Add("time_since_transfer", int64(60))
+	status.Add("label", "movies")
+	status.Add("labelplus_name", "")
+
+	var reply rencode.Dictionary
+	reply.Add("abc", status)
+	return reply
+}
+
+func TestTorrentsExtra(t *testing.T) {
+	daemon := newFakeDaemon(t, map[string]any{"core.get_torrents_status": torrentsStatusReply()})
+	d, err := NewDeluge(daemon.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extras, err := d.torrentsExtra(context.Background())
+	if err != nil {
+		t.Fatalf("torrentsExtra() error: %v", err)
+	}
+	expected := map[string]model.DelugeExtra{"abc": {
+		Trackers:          []string{"https://tracker.example/announce"},
+		TimeSinceTransfer: 60,
+		Label:             "movies",
+	}}
+	if !reflect.DeepEqual(extras, expected) {
+		t.Errorf("torrentsExtra() = %+v, want %+v", extras, expected)
+	}
+
+	// the connection is kept between calls
+	if _, err := d.torrentsExtra(context.Background()); err != nil {
+		t.Fatalf("torrentsExtra() error: %v", err)
+	}
+	if daemon.connections() != 1 {
+		t.Errorf("expected a single connection, got %d", daemon.connections())
+	}
+}
+
+func TestTorrentsExtraReconnect(t *testing.T) {
+	daemon := newFakeDaemon(t, map[string]any{"core.get_torrents_status": torrentsStatusReply()})
+	daemon.dropAfter = 2 // login and one call
+	d, err := NewDeluge(daemon.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 2 {
+		if _, err := d.torrentsExtra(context.Background()); err != nil {
+			t.Fatalf("call %d: torrentsExtra() error: %v", i, err)
+		}
+	}
+	if daemon.connections() != 2 {
+		t.Errorf("expected a reconnect after the connection dropped, got %d connections", daemon.connections())
+	}
+}
+
+func TestGetTorrentsExtraError(t *testing.T) {
+	daemon := newFakeDaemon(t, map[string]any{"core.get_torrents_status": rencode.Dictionary{}})
+	d, err := NewDeluge(daemon.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.rpc.dial = func(ctx context.Context) (*rpcConn, error) {
+		return nil, errors.New("connection refused")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := d.GetTorrents(ctx); err == nil {
+		t.Error("expected GetTorrents() to fail without the extra fields")
+	}
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limiter enforces the rate limit and concurrency limit of the options on every
+// request a backend sends, a nil Limiter doesn't limit anything.
+type Limiter struct {
+	sem  chan struct{}
+	rate *rate
+}
+
+// NewLimiter returns nil if neither rate_limit nor max_concurrent is set.
+func NewLimiter(opts Options) *Limiter {
+	if opts.RateLimit == 0 && opts.MaxConcurrent == 0 {
+		return nil
+	}
+
+	var l Limiter
+	if opts.MaxConcurrent > 0 {
+		l.sem = make(chan struct{}, opts.MaxConcurrent)
+	}
+	if opts.RateLimit > 0 {
+		l.rate = &rate{interval: time.Duration(float64(time.Second) / opts.RateLimit)}
+	}
+	return &l
+}
+
+// Acquire waits for a free slot and the next request time, release has to be
+// called once the request is done.
+func (l *Limiter) Acquire(ctx context.Context) (release func(), err error) {
+	release = func() {}
+	if l == nil {
+		return release, nil
+	}
+
+	if l.sem != nil {
+		select {
+		case l.sem <- struct{}{}:
+			release = func() { <-l.sem }
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if l.rate != nil {
+		if err := l.rate.wait(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+// Transport limits every request sent through base, which defaults to http.DefaultTransport.
+func Transport(base http.RoundTripper, l *Limiter) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if l == nil {
+		return base
+	}
+	return &transport{base: base, limiter: l}
+}
+
+type transport struct {
+	base    http.RoundTripper
+	limiter *Limiter
+}
+
+// RoundTrip holds the slot until the response headers arrive, the body is
+// left to the caller so a body that is never closed can't starve the limiter.
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.limiter.Acquire(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.base.RoundTrip(req)
+}
+
+// rate spaces requests at least interval apart.
+type rate struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func (r *rate) wait(ctx context.Context) error {
+	r.mu.Lock()
+	now := time.Now()
+	at := r.next
+	if at.Before(now) {
+		at = now
+	}
+	r.next = at.Add(r.interval)
+	r.mu.Unlock()
+
+	if d := at.Sub(now); d > 0 {
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
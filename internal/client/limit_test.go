@@ -0,0 +1,78 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransportRateLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	c := &http.Client{Transport: Transport(nil, NewLimiter(Options{RateLimit: 100}))}
+	start := time.Now()
+	for range 3 {
+		resp, err := c.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("Get() error: %v", err)
+		}
+		resp.Body.Close()
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected 3 requests at 100 per second to take at least 20ms, took %v", elapsed)
+	}
+}
+
+func TestTransportMaxConcurrent(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	c := &http.Client{Transport: Transport(nil, NewLimiter(Options{MaxConcurrent: 2}))}
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.Get(srv.URL)
+			if err != nil {
+				t.Errorf("Get() error: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	if p := peak.Load(); p > 2 {
+		t.Errorf("expected at most 2 requests in flight, got %d", p)
+	}
+}
+
+func TestNewLimiter(t *testing.T) {
+	if NewLimiter(Options{Timeout: time.Second, Retries: 3}) != nil {
+		t.Error("expected no limiter without rate_limit and max_concurrent")
+	}
+
+	// a nil limiter doesn't limit
+	var l *Limiter
+	release, err := l.Acquire(t.Context())
+	if err != nil {
+		t.Fatalf("Acquire() error: %v", err)
+	}
+	release()
+}
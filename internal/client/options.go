@@ -0,0 +1,50 @@
+package client
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/go-viper/mapstructure/v2"
+
+	"github.com/swkisdust/torrentremover/internal/utils"
+)
+
+// Options are shared by every client type and decoded from the same config map,
+// backends embed them with `mapstructure:",squash"`. Timeout, RateLimit and MaxConcurrent
+// are applied by the backend to each request (see Limiter), Retries by Wrap.
+type Options struct {
+	Timeout       time.Duration `mapstructure:"timeout"`        // per request, e.g. "30s" or 30
+	RateLimit     float64       `mapstructure:"rate_limit"`     // max requests per second, 0 is unlimited
+	MaxConcurrent int           `mapstructure:"max_concurrent"` // max requests in flight, 0 is unlimited
+	Retries       int           `mapstructure:"retries"`        // retries of failed requests, deletions are never retried
+}
+
+func (o Options) Validate() error {
+	if o.Timeout < 0 || o.RateLimit < 0 || o.MaxConcurrent < 0 || o.Retries < 0 {
+		return fmt.Errorf("timeout, rate_limit, max_concurrent and retries can't be negative")
+	}
+	return nil
+}
+
+// durationHook decodes durations like "1m30s", "2d" or a number of seconds.
+func durationHook() mapstructure.DecodeHookFunc {
+	return func(from, to reflect.Type, data any) (any, error) {
+		if to != reflect.TypeFor[time.Duration]() {
+			return data, nil
+		}
+		switch v := data.(type) {
+		case string:
+			return utils.ParseDuration(v)
+		case int:
+			return time.Duration(v) * time.Second, nil
+		case int64:
+			return time.Duration(v) * time.Second, nil
+		case uint64:
+			return time.Duration(v) * time.Second, nil
+		case float64:
+			return time.Duration(v * float64(time.Second)), nil
+		}
+		return data, nil
+	}
+}
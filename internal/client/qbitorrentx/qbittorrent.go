@@ -2,14 +2,16 @@ package qbitorrentx
 
 import (
 	"context"
+	"crypto/tls"
 	"log/slog"
+	"net/http"
 	"slices"
 	"strings"
 	"time"
 
 	"github.com/autobrr/go-qbittorrent"
-	"github.com/go-viper/mapstructure/v2"
 
+	"github.com/swkisdust/torrentremover/internal/client"
 	"github.com/swkisdust/torrentremover/internal/utils"
 	"github.com/swkisdust/torrentremover/model"
 )
@@ -22,21 +24,17 @@ type Qbitorrent struct {
 	BasicPass   string `mapstructure:"basic_pass"`
 	InsecureTLS bool   `mapstructure:"insecure_tls"`
 
+	client.Options `mapstructure:",squash"`
+
 	client *qbittorrent.Client
 }
 
 func NewQbittorrent(config map[string]any) (*Qbitorrent, error) {
 	var qb Qbitorrent
-	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
-		WeaklyTypedInput:     true,
-		IgnoreUntaggedFields: true,
-		Result:               &qb,
-	})
-	if err != nil {
+	if err := client.Decode(config, &qb); err != nil {
 		return nil, err
 	}
-
-	if err := decoder.Decode(config); err != nil {
+	if err := qb.Options.Validate(); err != nil {
 		return nil, err
 	}
 
@@ -49,6 +47,16 @@ func NewQbittorrent(config map[string]any) (*Qbitorrent, error) {
 		TLSSkipVerify: qb.InsecureTLS,
 	})
 
+	// the library only takes whole seconds, so the timeout is set on our own http client
+	if limiter := client.NewLimiter(qb.Options); qb.Timeout > 0 || limiter != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: qb.InsecureTLS}
+		qb.client.WithHTTPClient(&http.Client{
+			Timeout:   utils.IfOr(qb.Timeout > 0, qb.Timeout, qbittorrent.DefaultTimeout),
+			Transport: client.Transport(transport, limiter),
+		})
+	}
+
 	return &qb, nil
 }
 
@@ -89,13 +97,108 @@ func (qb *Qbitorrent) ResumeTorrents(ctx context.Context, torrents []*model.Torr
 	return qb.client.ResumeCtx(ctx, hashes)
 }
 
-func (qb *Qbitorrent) ThrottleTorrents(ctx context.Context, torrents []*model.Torrent, limit model.Bytes) error {
+func (qb *Qbitorrent) RecheckTorrents(ctx context.Context, torrents []*model.Torrent) error {
+	hashes := utils.SlicesMap(torrents,
+		func(t *model.Torrent) string {
+			return t.Hash
+		})
+
+	return qb.client.RecheckCtx(ctx, hashes)
+}
+
+func (qb *Qbitorrent) ForceStartTorrents(ctx context.Context, torrents []*model.Torrent) error {
 	hashes := utils.SlicesMap(torrents,
 		func(t *model.Torrent) string {
 			return t.Hash
 		})
 
-	return qb.client.SetTorrentUploadLimitCtx(ctx, hashes, int64(limit))
+	return qb.client.SetForceStartCtx(ctx, hashes, true)
+}
+
+func (qb *Qbitorrent) ReannounceTorrents(ctx context.Context, torrents []*model.Torrent) error {
+	hashes := utils.SlicesMap(torrents,
+		func(t *model.Torrent) string {
+			return t.Hash
+		})
+
+	return qb.client.ReAnnounceTorrentsCtx(ctx, hashes)
+}
+
+func (qb *Qbitorrent) TagTorrents(ctx context.Context, torrents []*model.Torrent, tags []string) error {
+	hashes := utils.SlicesMap(torrents,
+		func(t *model.Torrent) string {
+			return t.Hash
+		})
+
+	return qb.client.AddTagsCtx(ctx, hashes, strings.Join(tags, ","))
+}
+
+func (qb *Qbitorrent) ThrottleTorrents(ctx context.Context, torrents []*model.Torrent, limits model.Limits) error {
+	hashes := utils.SlicesMap(torrents,
+		func(t *model.Torrent) string {
+			return t.Hash
+		})
+
+	if limits.Upload != 0 {
+		if err := qb.client.SetTorrentUploadLimitCtx(ctx, hashes, max(int64(limits.Upload), -1)); err != nil {
+			return err
+		}
+	}
+
+	if limits.Download != 0 {
+		if err := qb.client.SetTorrentDownloadLimitCtx(ctx, hashes, max(int64(limits.Download), -1)); err != nil {
+			return err
+		}
+	}
+
+	if limits.Ratio != 0 || limits.SeedingTime != 0 || limits.GlobalShare {
+		// both limits are set at once, torrents keep the current value of the one not configured
+		groups := make(map[model.QbitShareLimits][]string)
+		for _, t := range torrents {
+			current, _ := t.ClientData.(model.QbitShareLimits)
+			share := shareLimits(limits, current)
+			groups[share] = append(groups[share], t.Hash)
+		}
+		for share, hashes := range groups {
+			if err := qb.client.SetTorrentShareLimitCtx(ctx, hashes, share.Ratio, share.SeedingTime, -2); err != nil {
+				return err
+			}
+		}
+	}
+
+	if limits.Group != nil && *limits.Group != "" {
+		slog.Warn("qbittorrent does not support bandwidth groups, ignored", "group", *limits.Group)
+	}
+
+	return nil
+}
+
+// shareLimits applies the configured limits on top of a torrent's current ones,
+// a torrent without known limits (zero value) falls back to the global limit (-2).
+func shareLimits(limits model.Limits, current model.QbitShareLimits) model.QbitShareLimits {
+	share := current
+	if share == (model.QbitShareLimits{}) {
+		share = model.QbitShareLimits{Ratio: -2, SeedingTime: -2}
+	}
+	if limits.GlobalShare {
+		return model.QbitShareLimits{Ratio: -2, SeedingTime: -2}
+	}
+	if limits.Ratio != 0 {
+		share.Ratio = max(limits.Ratio, -1)
+	}
+	if limits.SeedingTime != 0 {
+		share.SeedingTime = seedingTimeMinutes(limits.SeedingTime)
+	}
+	return share
+}
+
+// seedingTimeMinutes converts a seeding time limit to minutes, negative means no limit (-1)
+// and a positive limit under a minute is rounded up so it doesn't become 0.
+func seedingTimeMinutes(d time.Duration) int64 {
+	if d < 0 {
+		return -1
+	}
+	return int64((d + time.Minute - 1) / time.Minute)
 }
 
 func (qb *Qbitorrent) DeleteTorrents(ctx context.Context, torrents []*model.Torrent, name string, reannounce, deleteFiles bool, interval time.Duration) error {
@@ -155,3 +258,8 @@ func (qb *Qbitorrent) SessionStats(ctx context.Context) (model.SessionStats, err
 	stats.TotalUpSpeed = maindata.ServerState.UpInfoSpeed
 	return stats, nil
 }
+
+func (qb *Qbitorrent) Ping(ctx context.Context) error {
+	_, err := qb.client.GetAppVersionCtx(ctx)
+	return err
+}
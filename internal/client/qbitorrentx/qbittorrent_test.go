@@ -0,0 +1,103 @@
+package qbitorrentx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/swkisdust/torrentremover/model"
+)
+
+func TestShareLimits(t *testing.T) {
+	current := model.QbitShareLimits{Ratio: 3, SeedingTime: 600}
+
+	tests := []struct {
+		name     string
+		limits   model.Limits
+		current  model.QbitShareLimits
+		expected model.QbitShareLimits
+	}{
+		{"no limits", model.NoLimits, current, model.QbitShareLimits{Ratio: -2, SeedingTime: -2}},
+		{"global share overrides ratio", model.Limits{Ratio: 2, GlobalShare: true}, current, model.QbitShareLimits{Ratio: -2, SeedingTime: -2}},
+		{"ratio -1", model.Limits{Ratio: -1}, current, model.QbitShareLimits{Ratio: -1, SeedingTime: 600}},
+		{"seeding time -1s", model.Limits{SeedingTime: -time.Second}, current, model.QbitShareLimits{Ratio: 3, SeedingTime: -1}},
+		{"ratio keeps seeding time", model.Limits{Ratio: 2}, current, model.QbitShareLimits{Ratio: 2, SeedingTime: 600}},
+		{"seeding time keeps ratio", model.Limits{SeedingTime: 2 * time.Hour}, current, model.QbitShareLimits{Ratio: 3, SeedingTime: 120}},
+		{"sub-minute rounds up", model.Limits{SeedingTime: 30 * time.Second}, current, model.QbitShareLimits{Ratio: 3, SeedingTime: 1}},
+		{"unknown current is global", model.Limits{Ratio: 2}, model.QbitShareLimits{}, model.QbitShareLimits{Ratio: 2, SeedingTime: -2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shareLimits(tt.limits, tt.current); got != tt.expected {
+				t.Errorf("shareLimits() = %+v, want %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTorrentActions(t *testing.T) {
+	torrents := []*model.Torrent{{Hash: "abc"}, {Hash: "def"}}
+
+	tests := []struct {
+		name   string
+		action func(qb *Qbitorrent) error
+		path   string
+	}{
+		{"recheck", func(qb *Qbitorrent) error {
+			return qb.RecheckTorrents(context.Background(), torrents)
+		}, "/api/v2/torrents/recheck"},
+		{"force start", func(qb *Qbitorrent) error {
+			return qb.ForceStartTorrents(context.Background(), torrents)
+		}, "/api/v2/torrents/setForceStart"},
+		{"reannounce", func(qb *Qbitorrent) error {
+			return qb.ReannounceTorrents(context.Background(), torrents)
+		}, "/api/v2/torrents/reannounce"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var paths []string
+			var hashes string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				paths = append(paths, r.URL.Path)
+				hashes = r.FormValue("hashes")
+			}))
+			defer srv.Close()
+
+			qb, err := NewQbittorrent(map[string]any{"host": srv.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.action(qb); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(paths, []string{tt.path}) {
+				t.Errorf("requested %v, want [%s]", paths, tt.path)
+			}
+			if hashes != "abc|def" {
+				t.Errorf("sent hashes %q, want %q", hashes, "abc|def")
+			}
+		})
+	}
+}
+
+func TestSubSecondTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	qb, err := NewQbittorrent(map[string]any{"host": srv.URL, "timeout": "100ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a timeout truncated to whole seconds would let the request finish
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := qb.Ping(ctx); err == nil {
+		t.Error("expected the request to time out after 100ms")
+	}
+}
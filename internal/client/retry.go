@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Backoff retries an operation, doubling the delay after every failed attempt.
+type Backoff struct {
+	Initial  time.Duration
+	Max      time.Duration
+	Attempts int // 0 retries until the context is done
+}
+
+// DefaultBackoff is used to (re)connect to clients.
+var DefaultBackoff = Backoff{Initial: time.Second, Max: 30 * time.Second, Attempts: 4}
+
+// Retry calls fn until it succeeds, the attempts run out or ctx is done,
+// and returns the last error.
+func (b Backoff) Retry(ctx context.Context, fn func(ctx context.Context) error) error {
+	delay := b.Initial
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		if b.Attempts > 0 && attempt >= b.Attempts {
+			return err
+		}
+
+		slog.Debug("retrying after error", "attempt", attempt, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, b.Max)
+	}
+}
+
+// WaitReady pings a client until it responds or ctx is done.
+func WaitReady(ctx context.Context, c Client) error {
+	b := DefaultBackoff
+	b.Attempts = 0
+	return b.Retry(ctx, c.Ping)
+}
@@ -0,0 +1,41 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoffRetry(t *testing.T) {
+	b := Backoff{Initial: time.Millisecond, Max: 2 * time.Millisecond, Attempts: 3}
+	errTest := errors.New("test")
+
+	var calls int
+	err := b.Retry(context.Background(), func(context.Context) error {
+		calls++
+		return errTest
+	})
+	if !errors.Is(err, errTest) || calls != 3 {
+		t.Errorf("Retry() = %v after %d calls, expected the last error after 3 calls", err, calls)
+	}
+
+	calls = 0
+	err = b.Retry(context.Background(), func(context.Context) error {
+		calls++
+		if calls < 2 {
+			return errTest
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("Retry() = %v after %d calls, expected success after 2 calls", err, calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.Attempts = 0
+	if err := b.Retry(ctx, func(context.Context) error { return errTest }); !errors.Is(err, context.Canceled) {
+		t.Errorf("Retry() = %v, expected the context error", err)
+	}
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+
+	"github.com/go-viper/mapstructure/v2"
+)
+
+// Decode decodes a client config into result, a backend struct embedding Options.
+// Any key with a _file suffix, e.g. password_file, is replaced by the key without it
+// set to the trimmed content of the file, for docker secrets in /run/secrets.
+func Decode(config map[string]any, result any) error {
+	config, err := readFiles(config)
+	if err != nil {
+		return err
+	}
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		WeaklyTypedInput:     true,
+		IgnoreUntaggedFields: true,
+		DecodeHook:           durationHook(),
+		Result:               result,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(config)
+}
+
+func readFiles(config map[string]any) (map[string]any, error) {
+	resolved := maps.Clone(config)
+	for k, v := range config {
+		key, ok := strings.CutSuffix(k, "_file")
+		if !ok {
+			continue
+		}
+		path, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected a file path, got %T", k, v)
+		}
+		if _, ok := config[key]; ok {
+			return nil, fmt.Errorf("%s and %s can't both be set", key, k)
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		resolved[key] = strings.TrimRight(string(b), "\r\n")
+		delete(resolved, k)
+	}
+	return resolved, nil
+}
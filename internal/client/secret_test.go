@@ -0,0 +1,30 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDecode(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(secret, []byte("hunter2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var result struct {
+		Password string `mapstructure:"password"`
+		Options  `mapstructure:",squash"`
+	}
+	if err := Decode(map[string]any{"password_file": secret, "timeout": "1m", "retries": 2}, &result); err != nil {
+		t.Fatalf("Decode() error: %v", err)
+	}
+	if result.Password != "hunter2" || result.Timeout != time.Minute || result.Retries != 2 {
+		t.Errorf("Decode() = %+v", result)
+	}
+
+	if err := Decode(map[string]any{"password": "a", "password_file": secret}, &result); err == nil {
+		t.Error("expected password and password_file together to fail")
+	}
+}
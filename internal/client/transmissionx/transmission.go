@@ -2,47 +2,96 @@ package transmissionx
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"net/url"
+	"slices"
+	"strings"
 	"time"
 
-	"github.com/go-viper/mapstructure/v2"
 	"github.com/hekmon/transmissionrpc/v3"
 
+	"github.com/swkisdust/torrentremover/internal/client"
 	"github.com/swkisdust/torrentremover/internal/utils"
 	"github.com/swkisdust/torrentremover/model"
 )
 
+// fields requested by GetTorrents, it has to cover everything model.FromTrans reads
+// since that is all filters and exprs can see, so the set doesn't depend on the config.
+// TestTorrentFields fails if FromTrans starts reading a field missing here.
+var torrentFields = []string{
+	"id",
+	"hashString",
+	"name",
+	"status",
+	"addedDate",
+	"activityDate",
+	"secondsSeeding",
+	"secondsDownloading",
+	"uploadRatio",
+	"percentDone",
+	"labels",
+	"group",
+	"bandwidthPriority",
+	"totalSize",
+	"downloadDir",
+	"trackerStats",
+	"rateDownload",
+	"rateUpload",
+	"downloadedEver",
+	"uploadedEver",
+}
+
 type Transmission struct {
 	Host     string `mapstructure:"host"`
 	Username string `mapstructure:"username"`
 	Password string `mapstructure:"password"`
 
+	// How labels map to the category, empty leaves it unset,
+	// "first_label" uses the first label and "label_prefix" the label starting with CategoryPrefix.
+	CategoryFrom   string `mapstructure:"category_from"`
+	CategoryPrefix string `mapstructure:"category_prefix"`
+
+	client.Options `mapstructure:",squash"`
+
 	client *transmissionrpc.Client
 }
 
 func NewTransmission(config map[string]any) (*Transmission, error) {
 	var tr Transmission
-	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
-		WeaklyTypedInput:     true,
-		IgnoreUntaggedFields: true,
-		Result:               &tr,
-	})
-	if err != nil {
+	if err := client.Decode(config, &tr); err != nil {
 		return nil, err
 	}
-
-	if err := decoder.Decode(config); err != nil {
+	if err := tr.Options.Validate(); err != nil {
 		return nil, err
 	}
 
+	switch tr.CategoryFrom {
+	case "", "first_label":
+	case "label_prefix":
+		if tr.CategoryPrefix == "" {
+			tr.CategoryPrefix = "category:"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported category_from %q", tr.CategoryFrom)
+	}
+
 	endpoint, err := url.Parse(tr.Host)
 	if err != nil {
 		return nil, err
 	}
 	endpoint.User = url.UserPassword(tr.Username, tr.Password)
 
-	tr.client, err = transmissionrpc.New(endpoint, nil)
+	var extra *transmissionrpc.Config
+	if limiter := client.NewLimiter(tr.Options); tr.Timeout > 0 || limiter != nil {
+		extra = &transmissionrpc.Config{CustomClient: &http.Client{
+			Timeout:   tr.Timeout,
+			Transport: client.Transport(nil, limiter),
+		}}
+	}
+	tr.client, err = transmissionrpc.New(endpoint, extra)
 	if err != nil {
 		return nil, err
 	}
@@ -51,17 +100,33 @@ func NewTransmission(config map[string]any) (*Transmission, error) {
 }
 
 func (tr *Transmission) GetTorrents(ctx context.Context) ([]*model.Torrent, error) {
-	torrents, err := tr.client.TorrentGetAll(ctx)
+	torrents, err := tr.client.TorrentGet(ctx, torrentFields, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	return utils.SlicesMap(torrents,
 		func(tt transmissionrpc.Torrent) *model.Torrent {
-			return model.FromTrans(&tt)
+			return model.FromTrans(&tt, tr.category(tt.Labels))
 		}), nil
 }
 
+func (tr *Transmission) category(labels []string) string {
+	switch tr.CategoryFrom {
+	case "first_label":
+		if len(labels) > 0 {
+			return labels[0]
+		}
+	case "label_prefix":
+		for _, label := range labels {
+			if category, ok := strings.CutPrefix(label, tr.CategoryPrefix); ok {
+				return category
+			}
+		}
+	}
+	return ""
+}
+
 func (tr *Transmission) PauseTorrents(ctx context.Context, torrents []*model.Torrent) error {
 	ids := utils.SlicesMap(torrents,
 		func(t *model.Torrent) int64 {
@@ -80,25 +145,100 @@ func (tr *Transmission) ResumeTorrents(ctx context.Context, torrents []*model.To
 	return tr.client.TorrentStartIDs(ctx, ids)
 }
 
-func (tr *Transmission) ThrottleTorrents(ctx context.Context, torrents []*model.Torrent, limit model.Bytes) error {
+func (tr *Transmission) RecheckTorrents(ctx context.Context, torrents []*model.Torrent) error {
+	ids := utils.SlicesMap(torrents,
+		func(t *model.Torrent) int64 {
+			return t.ClientData.(int64)
+		})
+
+	return tr.client.TorrentVerifyIDs(ctx, ids)
+}
+
+func (tr *Transmission) ForceStartTorrents(ctx context.Context, torrents []*model.Torrent) error {
 	ids := utils.SlicesMap(torrents,
 		func(t *model.Torrent) int64 {
 			return t.ClientData.(int64)
 		})
 
-	var uploadSpeed int64
-	if limit == -1 {
-		uploadSpeed = -1
-	} else {
-		uploadSpeed = limit.KB()
+	return tr.client.TorrentStartNowIDs(ctx, ids)
+}
+
+func (tr *Transmission) ReannounceTorrents(ctx context.Context, torrents []*model.Torrent) error {
+	ids := utils.SlicesMap(torrents,
+		func(t *model.Torrent) int64 {
+			return t.ClientData.(int64)
+		})
+
+	return tr.client.TorrentReannounceIDs(ctx, ids)
+}
+
+func (tr *Transmission) TagTorrents(ctx context.Context, torrents []*model.Torrent, tags []string) error {
+	var wrapErr error
+	for _, t := range torrents {
+		labels := slices.Clone(t.Tags)
+		for _, tag := range tags {
+			if !slices.Contains(labels, tag) {
+				labels = append(labels, tag)
+			}
+		}
+
+		// labels are replaced as a whole, so each torrent is updated on its own
+		if err := tr.client.TorrentSet(ctx, transmissionrpc.TorrentSetPayload{
+			IDs:    []int64{t.ClientData.(int64)},
+			Labels: labels,
+		}); err != nil {
+			wrapErr = errors.Join(wrapErr, err)
+			continue
+		}
 	}
-	limited := utils.IfOr(uploadSpeed < 1, false, true)
 
-	return tr.client.TorrentSet(ctx, transmissionrpc.TorrentSetPayload{
-		IDs:           ids,
-		UploadLimit:   &uploadSpeed,
-		UploadLimited: &limited,
-	})
+	return wrapErr
+}
+
+func (tr *Transmission) ThrottleTorrents(ctx context.Context, torrents []*model.Torrent, limits model.Limits) error {
+	ids := utils.SlicesMap(torrents,
+		func(t *model.Torrent) int64 {
+			return t.ClientData.(int64)
+		})
+
+	return tr.client.TorrentSet(ctx, limitsPayload(ids, limits))
+}
+
+func limitsPayload(ids []int64, limits model.Limits) transmissionrpc.TorrentSetPayload {
+	payload := transmissionrpc.TorrentSetPayload{IDs: ids}
+	if limits.Upload != 0 {
+		uploadSpeed, limited := speedLimit(limits.Upload)
+		payload.UploadLimit, payload.UploadLimited = &uploadSpeed, &limited
+	}
+	if limits.Download != 0 {
+		downloadSpeed, limited := speedLimit(limits.Download)
+		payload.DownloadLimit, payload.DownloadLimited = &downloadSpeed, &limited
+	}
+	if limits.GlobalShare {
+		mode := transmissionrpc.SeedRatioModeGlobal
+		payload.SeedRatioMode = &mode
+	} else if limits.Ratio != 0 {
+		ratio := max(limits.Ratio, 0)
+		mode := utils.IfOr(limits.Ratio < 0, transmissionrpc.SeedRatioModeNoRatio, transmissionrpc.SeedRatioModeCustom)
+		payload.SeedRatioLimit, payload.SeedRatioMode = &ratio, &mode
+	}
+	if limits.SeedingTime > 0 {
+		slog.Warn("transmission does not support per-torrent seeding time limits, ignored", "limit", limits.SeedingTime)
+	}
+	if limits.Group != nil {
+		payload.Group = limits.Group
+	}
+
+	return payload
+}
+
+func speedLimit(limit model.Bytes) (int64, bool) {
+	if limit < 0 {
+		return -1, false
+	}
+
+	speed := limit.KB()
+	return speed, speed >= 1
 }
 
 func (tr *Transmission) DeleteTorrents(ctx context.Context, torrents []*model.Torrent, name string, reannounce, deleteFiles bool, interval time.Duration) error {
@@ -158,3 +298,8 @@ func (tr *Transmission) SessionStats(ctx context.Context) (model.SessionStats, e
 	stats.TotalUpSpeed = tstats.UploadSpeed
 	return stats, nil
 }
+
+func (tr *Transmission) Ping(ctx context.Context) error {
+	_, _, _, err := tr.client.RPCVersion(ctx)
+	return err
+}
@@ -0,0 +1,194 @@
+package transmissionx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/hekmon/transmissionrpc/v3"
+
+	"github.com/swkisdust/torrentremover/model"
+)
+
+func TestLimitsPayload(t *testing.T) {
+	payload := limitsPayload([]int64{1}, model.NoLimits)
+	if *payload.UploadLimited || *payload.DownloadLimited {
+		t.Error("expected no limits to disable the speed limits")
+	}
+	if *payload.SeedRatioMode != transmissionrpc.SeedRatioModeGlobal || payload.SeedRatioLimit != nil {
+		t.Errorf("expected no limits to restore the global ratio limit, got mode %v", *payload.SeedRatioMode)
+	}
+	if payload.Group == nil || *payload.Group != "" {
+		t.Error("expected no limits to clear the bandwidth group")
+	}
+
+	payload = limitsPayload([]int64{1}, model.Limits{Ratio: 2})
+	if *payload.SeedRatioLimit != 2 || *payload.SeedRatioMode != transmissionrpc.SeedRatioModeCustom {
+		t.Errorf("expected a custom ratio of 2, got %v mode %v", *payload.SeedRatioLimit, *payload.SeedRatioMode)
+	}
+	if payload.UploadLimit != nil || payload.DownloadLimit != nil || payload.Group != nil {
+		t.Error("expected limits that aren't configured to be left untouched")
+	}
+
+	payload = limitsPayload([]int64{1}, model.Limits{Ratio: -1})
+	if *payload.SeedRatioMode != transmissionrpc.SeedRatioModeNoRatio {
+		t.Errorf("expected ratio -1 to disable the ratio limit, got mode %v", *payload.SeedRatioMode)
+	}
+
+	payload = limitsPayload([]int64{1}, model.Limits{Upload: 2048})
+	if *payload.UploadLimit != 2 || !*payload.UploadLimited {
+		t.Errorf("expected a 2 KB/s upload limit, got %v limited %v", *payload.UploadLimit, *payload.UploadLimited)
+	}
+}
+
+func TestTorrentActions(t *testing.T) {
+	torrents := []*model.Torrent{{ClientData: int64(1)}, {ClientData: int64(2)}}
+
+	tests := []struct {
+		name   string
+		action func(tr *Transmission) error
+		method string
+	}{
+		{"recheck", func(tr *Transmission) error {
+			return tr.RecheckTorrents(context.Background(), torrents)
+		}, "torrent-verify"},
+		{"force start", func(tr *Transmission) error {
+			return tr.ForceStartTorrents(context.Background(), torrents)
+		}, "torrent-start-now"},
+		{"reannounce", func(tr *Transmission) error {
+			return tr.ReannounceTorrents(context.Background(), torrents)
+		}, "torrent-reannounce"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var methods []string
+			var ids []int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// transmission rejects requests without the session id it hands out
+				if r.Header.Get("X-Transmission-Session-Id") != "session" {
+					w.Header().Set("X-Transmission-Session-Id", "session")
+					w.WriteHeader(http.StatusConflict)
+					return
+				}
+
+				var req struct {
+					Method    string `json:"method"`
+					Tag       int    `json:"tag"`
+					Arguments struct {
+						IDs []int64 `json:"ids"`
+					} `json:"arguments"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					t.Errorf("decode request: %v", err)
+				}
+				methods = append(methods, req.Method)
+				ids = req.Arguments.IDs
+				fmt.Fprintf(w, `{"result":"success","arguments":{},"tag":%d}`, req.Tag)
+			}))
+			defer srv.Close()
+
+			tr, err := NewTransmission(map[string]any{"host": srv.URL + "/transmission/rpc"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.action(tr); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(methods, []string{tt.method}) {
+				t.Errorf("called %v, want [%s]", methods, tt.method)
+			}
+			if !slices.Equal(ids, []int64{1, 2}) {
+				t.Errorf("sent ids %v, want [1 2]", ids)
+			}
+		})
+	}
+}
+
+// fullTorrent sets every field a torrent-get can return that model.FromTrans reads.
+const fullTorrent = `{
+	"id": 7,
+	"hashString": "abc",
+	"name": "name",
+	"status": 6,
+	"addedDate": 1700000000,
+	"activityDate": 1700003600,
+	"secondsSeeding": 7200,
+	"secondsDownloading": 600,
+	"uploadRatio": 1.5,
+	"percentDone": 1,
+	"labels": ["movies", "hd"],
+	"group": "slow",
+	"bandwidthPriority": 1,
+	"totalSize": 4096,
+	"downloadDir": "/data",
+	"trackerStats": [{"announce": "https://tracker.example/announce", "leecherCount": 2, "seederCount": 5, "lastAnnounceResult": "Success", "lastAnnounceSucceeded": true, "lastScrapeTimedOut": false}],
+	"rateDownload": 10,
+	"rateUpload": 20,
+	"downloadedEver": 4096,
+	"uploadedEver": 6144
+}`
+
+// TestTorrentFields checks that torrentFields covers everything model.FromTrans reads,
+// filters and exprs only see the model so no other field is needed.
+func TestTorrentFields(t *testing.T) {
+	var full map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(fullTorrent), &full); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Transmission-Session-Id") != "session" {
+			w.Header().Set("X-Transmission-Session-Id", "session")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		var req struct {
+			Tag       int `json:"tag"`
+			Arguments struct {
+				Fields []string `json:"fields"`
+			} `json:"arguments"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		// like transmission, only the requested fields are returned
+		torrent := make(map[string]json.RawMessage)
+		for _, f := range req.Arguments.Fields {
+			if v, ok := full[f]; ok {
+				torrent[f] = v
+			}
+		}
+		b, _ := json.Marshal(torrent)
+		fmt.Fprintf(w, `{"result":"success","arguments":{"torrents":[%s]},"tag":%d}`, b, req.Tag)
+	}))
+	defer srv.Close()
+
+	tr, err := NewTransmission(map[string]any{"host": srv.URL + "/transmission/rpc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	torrents, err := tr.GetTorrents(context.Background())
+	if err != nil {
+		t.Fatalf("GetTorrents() error: %v", err)
+	}
+	if len(torrents) != 1 {
+		t.Fatalf("expected 1 torrent, got %d", len(torrents))
+	}
+
+	var expected transmissionrpc.Torrent
+	if err := json.Unmarshal([]byte(fullTorrent), &expected); err != nil {
+		t.Fatal(err)
+	}
+	want := model.FromTrans(&expected, "")
+	got := torrents[0]
+	got.TimeElapsed, want.TimeElapsed = 0, 0
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("torrent from torrentFields differs from the full torrent:\n got %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/swkisdust/torrentremover/model"
+)
+
+// Wrap retries failed calls of a client as set by the options, the rate and
+// concurrency limits are enforced per request by the backends, see Limiter.
+func Wrap(c Client, opts Options) Client {
+	if opts.Retries == 0 {
+		return c
+	}
+	return &wrapped{c: c, opts: opts}
+}
+
+type wrapped struct {
+	c    Client
+	opts Options
+}
+
+// call runs fn, retrying failed calls with backoff if retry is set.
+func (w *wrapped) call(ctx context.Context, retry bool, fn func(ctx context.Context) error) error {
+	if !retry {
+		return fn(ctx)
+	}
+
+	// errors that won't go away are returned without retrying
+	var permanent error
+	b := Backoff{Initial: 500 * time.Millisecond, Max: 10 * time.Second, Attempts: w.opts.Retries + 1}
+	err := b.Retry(ctx, func(ctx context.Context) error {
+		err := fn(ctx)
+		if errors.Is(err, errors.ErrUnsupported) || errors.Is(err, context.Canceled) {
+			permanent = err
+			return nil
+		}
+		return err
+	})
+	if permanent != nil {
+		return permanent
+	}
+	return err
+}
+
+func (w *wrapped) GetTorrents(ctx context.Context) (torrents []*model.Torrent, err error) {
+	err = w.call(ctx, true, func(ctx context.Context) (err error) {
+		torrents, err = w.c.GetTorrents(ctx)
+		return err
+	})
+	return torrents, err
+}
+
+func (w *wrapped) PauseTorrents(ctx context.Context, torrents []*model.Torrent) error {
+	return w.call(ctx, true, func(ctx context.Context) error {
+		return w.c.PauseTorrents(ctx, torrents)
+	})
+}
+
+func (w *wrapped) ResumeTorrents(ctx context.Context, torrents []*model.Torrent) error {
+	return w.call(ctx, true, func(ctx context.Context) error {
+		return w.c.ResumeTorrents(ctx, torrents)
+	})
+}
+
+func (w *wrapped) RecheckTorrents(ctx context.Context, torrents []*model.Torrent) error {
+	return w.call(ctx, true, func(ctx context.Context) error {
+		return w.c.RecheckTorrents(ctx, torrents)
+	})
+}
+
+func (w *wrapped) ForceStartTorrents(ctx context.Context, torrents []*model.Torrent) error {
+	return w.call(ctx, true, func(ctx context.Context) error {
+		return w.c.ForceStartTorrents(ctx, torrents)
+	})
+}
+
+func (w *wrapped) ReannounceTorrents(ctx context.Context, torrents []*model.Torrent) error {
+	return w.call(ctx, true, func(ctx context.Context) error {
+		return w.c.ReannounceTorrents(ctx, torrents)
+	})
+}
+
+func (w *wrapped) TagTorrents(ctx context.Context, torrents []*model.Torrent, tags []string) error {
+	return w.call(ctx, true, func(ctx context.Context) error {
+		return w.c.TagTorrents(ctx, torrents, tags)
+	})
+}
+
+func (w *wrapped) ThrottleTorrents(ctx context.Context, torrents []*model.Torrent, limits model.Limits) error {
+	return w.call(ctx, true, func(ctx context.Context) error {
+		return w.c.ThrottleTorrents(ctx, torrents, limits)
+	})
+}
+
+// DeleteTorrents is not retried, a failed call may have removed some torrents
+// and files already.
+func (w *wrapped) DeleteTorrents(ctx context.Context, torrents []*model.Torrent, name string, reannounce, deleteFiles bool, interval time.Duration) error {
+	return w.call(ctx, false, func(ctx context.Context) error {
+		return w.c.DeleteTorrents(ctx, torrents, name, reannounce, deleteFiles, interval)
+	})
+}
+
+func (w *wrapped) GetFreeSpaceOnDisk(ctx context.Context, path string) (free model.Bytes, err error) {
+	err = w.call(ctx, true, func(ctx context.Context) (err error) {
+		free, err = w.c.GetFreeSpaceOnDisk(ctx, path)
+		return err
+	})
+	return free, err
+}
+
+func (w *wrapped) SessionStats(ctx context.Context) (stats model.SessionStats, err error) {
+	err = w.call(ctx, true, func(ctx context.Context) (err error) {
+		stats, err = w.c.SessionStats(ctx)
+		return err
+	})
+	return stats, err
+}
+
+func (w *wrapped) Ping(ctx context.Context) error {
+	return w.call(ctx, true, w.c.Ping)
+}
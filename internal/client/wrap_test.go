@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/swkisdust/torrentremover/model"
+)
+
+// failingClient fails every call with err and counts the calls.
+type failingClient struct {
+	Client
+	err   error
+	calls int
+}
+
+func (c *failingClient) Ping(ctx context.Context) error {
+	c.calls++
+	return c.err
+}
+
+func (c *failingClient) RecheckTorrents(ctx context.Context, torrents []*model.Torrent) error {
+	c.calls++
+	return c.err
+}
+
+func (c *failingClient) DeleteTorrents(ctx context.Context, torrents []*model.Torrent, name string, reannounce, deleteFiles bool, interval time.Duration) error {
+	c.calls++
+	return c.err
+}
+
+func TestWrapRetries(t *testing.T) {
+	ctx := context.Background()
+	opts := Options{Retries: 1}
+
+	c := &failingClient{err: errors.New("test")}
+	_ = Wrap(c, opts).Ping(ctx)
+	if c.calls != 2 {
+		t.Errorf("expected a failed ping to be retried once, got %d calls", c.calls)
+	}
+
+	c = &failingClient{err: errors.New("test")}
+	_ = Wrap(c, opts).DeleteTorrents(ctx, nil, "test", false, false, 0)
+	if c.calls != 1 {
+		t.Errorf("expected deletions not to be retried, got %d calls", c.calls)
+	}
+
+	c = &failingClient{err: fmt.Errorf("recheck: %w", errors.ErrUnsupported)}
+	if err := Wrap(c, opts).RecheckTorrents(ctx, nil); !errors.Is(err, errors.ErrUnsupported) || c.calls != 1 {
+		t.Errorf("expected unsupported calls not to be retried, got %v after %d calls", err, c.calls)
+	}
+}
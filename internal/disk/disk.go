@@ -0,0 +1,96 @@
+package disk
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrNotLocal is returned by Local in auto mode for paths that don't exist on this host.
+var ErrNotLocal = errors.New("path is not available locally")
+
+// FreeSpace returns the space available to unprivileged users on the disk holding path.
+func FreeSpace(path string) (int64, error) {
+	return freeSpace(path)
+}
+
+// MountPoint returns the mount point (or volume on windows) of path.
+func MountPoint(path string) (string, error) {
+	return mountPoint(filepath.Clean(path))
+}
+
+// Local looks up the free space of the disks the client saves torrents to,
+// for when torrentremover runs on the same host as the client.
+// Client paths are translated with PathMap first, e.g. {"/downloads": "/mnt/disk1"}
+// for a client running in a container. Results are cached per mount for the
+// lifetime of the Local, so a new one is needed once torrents were removed.
+type Local struct {
+	PathMap map[string]string
+	Auto    bool // return ErrNotLocal for paths that don't exist instead of failing
+
+	mu     sync.Mutex
+	mounts map[string]string
+	free   map[string]int64
+}
+
+func NewLocal(pathMap map[string]string, auto bool) *Local {
+	return &Local{
+		PathMap: pathMap,
+		Auto:    auto,
+		mounts:  make(map[string]string),
+		free:    make(map[string]int64),
+	}
+}
+
+// Path translates a client path to a local one using the longest matching PathMap prefix.
+func (l *Local) Path(clientPath string) string {
+	var from, to string
+	for prefix, local := range l.PathMap {
+		if len(prefix) > len(from) && hasPathPrefix(clientPath, prefix) {
+			from, to = prefix, local
+		}
+	}
+	if from == "" {
+		return clientPath
+	}
+	return filepath.Join(to, strings.TrimPrefix(clientPath, from))
+}
+
+// FreeSpace returns the mount of a client path and its free space.
+func (l *Local) FreeSpace(clientPath string) (string, int64, error) {
+	path := l.Path(clientPath)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	mount, ok := l.mounts[path]
+	if !ok {
+		if _, err := os.Stat(path); l.Auto && errors.Is(err, os.ErrNotExist) {
+			return "", 0, ErrNotLocal
+		}
+
+		var err error
+		if mount, err = MountPoint(path); err != nil {
+			return "", 0, err
+		}
+		l.mounts[path] = mount
+	}
+
+	free, ok := l.free[mount]
+	if !ok {
+		var err error
+		if free, err = FreeSpace(mount); err != nil {
+			return mount, 0, err
+		}
+		l.free[mount] = free
+	}
+
+	return mount, free, nil
+}
+
+func hasPathPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || windows)
+
+package disk
+
+import "errors"
+
+func freeSpace(path string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func mountPoint(path string) (string, error) {
+	return path, nil
+}
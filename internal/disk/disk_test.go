@@ -0,0 +1,44 @@
+package disk
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	l := NewLocal(map[string]string{
+		"/downloads":     "/mnt/disk1",
+		"/downloads/tv/": "/mnt/disk2",
+	}, false)
+
+	tests := map[string]string{
+		"/downloads":          "/mnt/disk1",
+		"/downloads/movies/a": "/mnt/disk1/movies/a",
+		"/downloads/tv/b":     "/mnt/disk2/b",
+		"/downloads-old/c":    "/downloads-old/c",
+		"/data":               "/data",
+	}
+	for clientPath, expected := range tests {
+		if got := l.Path(clientPath); got != filepath.FromSlash(expected) {
+			t.Errorf("Path(%q) = %q, want %q", clientPath, got, expected)
+		}
+	}
+}
+
+func TestLocalFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+
+	l := NewLocal(map[string]string{"/downloads": dir}, true)
+	mount, free, err := l.FreeSpace("/downloads")
+	if err != nil {
+		t.Fatalf("FreeSpace() error: %v", err)
+	}
+	if mount == "" || free <= 0 {
+		t.Errorf("FreeSpace() = %q, %d, expected a mount and free space", mount, free)
+	}
+
+	if _, _, err := l.FreeSpace("/downloads/missing"); !errors.Is(err, ErrNotLocal) {
+		t.Errorf("expected ErrNotLocal for a missing path in auto mode, got %v", err)
+	}
+}
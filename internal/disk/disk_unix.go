@@ -0,0 +1,44 @@
+//go:build linux || darwin || freebsd
+
+package disk
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func freeSpace(path string) (int64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), nil
+}
+
+// mountPoint walks up from path until the device changes.
+func mountPoint(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	dev := fi.Sys().(*syscall.Stat_t).Dev
+
+	for {
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path, nil
+		}
+
+		fi, err := os.Stat(parent)
+		if err != nil {
+			return "", err
+		}
+		if fi.Sys().(*syscall.Stat_t).Dev != dev {
+			return path, nil
+		}
+		path = parent
+	}
+}
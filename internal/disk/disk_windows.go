@@ -0,0 +1,26 @@
+//go:build windows
+
+package disk
+
+import (
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+func freeSpace(path string) (int64, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return int64(free), nil
+}
+
+func mountPoint(path string) (string, error) {
+	return filepath.VolumeName(path) + `\`, nil
+}
@@ -0,0 +1,165 @@
+package exprx
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/swkisdust/torrentremover/model"
+)
+
+// groupBy groups torrents by category, tracker (host), tag, status or group.
+// A torrent with several trackers or tags is part of each of their groups.
+func groupBy(torrents []any, key string) (map[string][]any, error) {
+	keys, err := groupKeyFunc(key)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]any)
+	for _, item := range torrents {
+		t, err := toTorrent(item)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys(t) {
+			groups[k] = append(groups[k], item)
+		}
+	}
+	return groups, nil
+}
+
+func groupKeyFunc(key string) (func(t *model.Torrent) []string, error) {
+	switch key {
+	case "category":
+		return func(t *model.Torrent) []string { return []string{t.Category} }, nil
+	case "tracker":
+		return trackerHosts, nil
+	case "tag":
+		return func(t *model.Torrent) []string { return t.Tags }, nil
+	case "status":
+		return func(t *model.Torrent) []string { return []string{t.Status.String()} }, nil
+	case "group":
+		return func(t *model.Torrent) []string { return []string{t.Group} }, nil
+	default:
+		return nil, fmt.Errorf("group_by: unsupported key %q", key)
+	}
+}
+
+// topN returns the n torrents with the largest key, e.g. top_n(torrents, 3, "added_time")
+// are the 3 newest ones. A key prefixed with "-" returns the smallest instead.
+func topN(torrents []any, n int, key string) ([]any, error) {
+	desc := !strings.HasPrefix(key, "-")
+	value, err := sortKeyFunc(strings.TrimPrefix(key, "-"))
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := make([]*model.Torrent, 0, len(torrents))
+	for _, item := range torrents {
+		t, err := toTorrent(item)
+		if err != nil {
+			return nil, err
+		}
+		sorted = append(sorted, t)
+	}
+
+	slices.SortStableFunc(sorted, func(a, b *model.Torrent) int {
+		if desc {
+			return cmp.Compare(value(b), value(a))
+		}
+		return cmp.Compare(value(a), value(b))
+	})
+
+	result := make([]any, 0, min(max(n, 0), len(sorted)))
+	for _, t := range sorted[:cap(result)] {
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+func sortKeyFunc(key string) (func(t *model.Torrent) float64, error) {
+	switch key {
+	case "size":
+		return func(t *model.Torrent) float64 { return float64(t.Size) }, nil
+	case "ratio":
+		return func(t *model.Torrent) float64 { return t.Ratio }, nil
+	case "progress":
+		return func(t *model.Torrent) float64 { return t.Progress }, nil
+	case "seeder":
+		return func(t *model.Torrent) float64 { return float64(t.Seeder) }, nil
+	case "leecher":
+		return func(t *model.Torrent) float64 { return float64(t.Leecher) }, nil
+	case "uploaded":
+		return func(t *model.Torrent) float64 { return float64(t.Uploaded) }, nil
+	case "downloaded":
+		return func(t *model.Torrent) float64 { return float64(t.Downloaded) }, nil
+	case "up_speed":
+		return func(t *model.Torrent) float64 { return float64(t.UpSpeed) }, nil
+	case "dl_speed":
+		return func(t *model.Torrent) float64 { return float64(t.DlSpeed) }, nil
+	case "seeding_time":
+		return func(t *model.Torrent) float64 { return float64(t.SeedingTime) }, nil
+	case "time_elapsed":
+		return func(t *model.Torrent) float64 { return float64(t.TimeElapsed) }, nil
+	case "added_time":
+		return func(t *model.Torrent) float64 { return float64(t.AddedTime.Unix()) }, nil
+	case "last_activity":
+		return func(t *model.Torrent) float64 { return float64(t.LastActivity.Unix()) }, nil
+	default:
+		return nil, fmt.Errorf("top_n: unsupported key %q", key)
+	}
+}
+
+func sumSize(torrents []any) (int64, error) {
+	var sum int64
+	for _, item := range torrents {
+		t, err := toTorrent(item)
+		if err != nil {
+			return 0, err
+		}
+		sum += t.Size
+	}
+	return sum, nil
+}
+
+// countByTracker counts the torrents of every tracker host.
+func countByTracker(torrents []any) (map[string]int, error) {
+	counts := make(map[string]int)
+	for _, item := range torrents {
+		t, err := toTorrent(item)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range trackerHosts(t) {
+			counts[host]++
+		}
+	}
+	return counts, nil
+}
+
+// trackerHosts returns the distinct hosts of a torrent's trackers,
+// deluge may only report a bare host instead of the announce URL.
+func trackerHosts(t *model.Torrent) []string {
+	hosts := make([]string, 0, len(t.Trackers))
+	for _, tt := range t.Trackers {
+		host := tt.URL
+		if u, err := url.Parse(tt.URL); err == nil && u.Hostname() != "" {
+			host = u.Hostname()
+		}
+		if host != "" && !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+func toTorrent(item any) (*model.Torrent, error) {
+	t, ok := item.(*model.Torrent)
+	if !ok {
+		return nil, fmt.Errorf("expected a torrent, got %T", item)
+	}
+	return t, nil
+}
@@ -0,0 +1,90 @@
+package exprx
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+
+	"github.com/swkisdust/torrentremover/internal/client"
+	"github.com/swkisdust/torrentremover/internal/utils"
+	"github.com/swkisdust/torrentremover/model"
+)
+
+// CrossSeed makes removals aware of the torrents of every client seeding the same data.
+type CrossSeed struct {
+	Mode    string // model.CrossSeedTogether or model.CrossSeedKeepFiles
+	Client  string // name of the client the strategy runs on
+	Clients map[string]client.Client
+	Index   *model.TorrentIndex
+}
+
+// delete removes the torrents from the strategy's client, in together mode their
+// cross-seeds are removed from every other client too, in keep_files mode the files
+// of torrents that are still referenced elsewhere are kept.
+func (cs *CrossSeed) delete(ctx context.Context, c client.Client, ft []*model.Torrent, name string, options RunOptions) error {
+	interval := options.Interval
+	selected := make(map[string]bool, len(ft))
+	for _, t := range ft {
+		selected[t.Hash] = true
+	}
+
+	// torrents removed together with the selected ones, by client and hash
+	others := make(map[string]map[string]*model.Torrent)
+	var shared, own []*model.Torrent
+	for _, t := range ft {
+		refs := utils.SlicesFilter(func(ref model.TorrentRef) bool {
+			return ref.Client != cs.Client || !selected[ref.Torrent.Hash]
+		}, cs.Index.Others(cs.Client, t))
+		if len(refs) == 0 {
+			own = append(own, t)
+			continue
+		}
+		shared = append(shared, t)
+		for _, ref := range refs {
+			if others[ref.Client] == nil {
+				others[ref.Client] = make(map[string]*model.Torrent)
+			}
+			others[ref.Client][ref.Torrent.Hash] = ref.Torrent
+		}
+	}
+
+	switch cs.Mode {
+	case model.CrossSeedKeepFiles:
+		if len(own) > 0 {
+			if err := c.DeleteTorrents(ctx, own, name, options.Reannounce, options.DeleteFiles, interval); err != nil {
+				return err
+			}
+		}
+		if len(shared) > 0 {
+			slog.Info("keeping files of cross-seeded torrents", "strategy", name, "filtered", len(shared))
+			if err := c.DeleteTorrents(ctx, shared, name, options.Reannounce, false, interval); err != nil {
+				return err
+			}
+		}
+	default:
+		if err := c.DeleteTorrents(ctx, ft, name, options.Reannounce, options.DeleteFiles, interval); err != nil {
+			return err
+		}
+		for clientName, byHash := range others {
+			ts := slices.Collect(maps.Values(byHash))
+			oc, ok := cs.Clients[clientName]
+			if !ok {
+				return fmt.Errorf("cross-seed client %q not found", clientName)
+			}
+			slog.Info("removing cross-seeded torrents", "strategy", name, "client_id", clientName, "filtered", len(ts))
+			if err := oc.DeleteTorrents(ctx, ts, name, options.Reannounce, options.DeleteFiles, interval); err != nil {
+				return fmt.Errorf("client %s: %w", clientName, err)
+			}
+			for _, t := range ts {
+				cs.Index.Remove(clientName, t)
+			}
+		}
+	}
+
+	for _, t := range ft {
+		cs.Index.Remove(cs.Client, t)
+	}
+	return nil
+}
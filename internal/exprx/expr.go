@@ -12,6 +12,7 @@ import (
 	"github.com/expr-lang/expr/vm"
 
 	"github.com/swkisdust/torrentremover/internal/client"
+	"github.com/swkisdust/torrentremover/internal/state"
 	"github.com/swkisdust/torrentremover/internal/utils"
 	"github.com/swkisdust/torrentremover/model"
 )
@@ -22,12 +23,91 @@ type RemoveExpr struct {
 }
 
 type env struct {
-	Torrents     []any                         `expr:"torrents"` // []model.Torrent
-	Disk         int64                         `expr:"disk"`
-	WantSpace    int64                         `expr:"want_space"`
-	SessionStats model.SessionStats            `expr:"stats"`
-	Bytes        func(s string) (int64, error) `expr:"bytes"`
-	Cmp          func(a, b int64) int          `expr:"cmp"`
+	Torrents     []any              `expr:"torrents"`     // []model.Torrent
+	AllTorrents  []any              `expr:"all_torrents"` // every torrent of the client, before filters
+	Disk         int64              `expr:"disk"`
+	WantSpace    int64              `expr:"want_space"`
+	SessionStats model.SessionStats `expr:"stats"`
+
+	funcs
+}
+
+// torrentEnv is evaluated once per torrent, used by match and score_expr.
+// The torrent's fields are top-level identifiers, e.g. ratio > 2.
+type torrentEnv struct {
+	*model.Torrent
+	Self         *model.Torrent     `expr:"torrent"` // the torrent itself, e.g. age(torrent)
+	AllTorrents  []any              `expr:"all_torrents"`
+	Disk         int64              `expr:"disk"`
+	WantSpace    int64              `expr:"want_space"`
+	SessionStats model.SessionStats `expr:"stats"`
+
+	funcs
+}
+
+// funcs are the helpers shared by every env.
+type funcs struct {
+	Bytes func(s string) (int64, error) `expr:"bytes"`
+	Cmp   func(a, b int64) int          `expr:"cmp"`
+
+	TrackerUnregistered func(t *model.Torrent) bool `expr:"tracker_unregistered"`
+	TrackerError        func(t *model.Torrent) bool `expr:"tracker_error"`
+	TrackerWorking      func(t *model.Torrent) bool `expr:"tracker_working"`
+
+	TrackerStatus map[string]model.TrackerStatus `expr:"tracker_status"` // e.g. tracker_status.working
+
+	TorrentDiskFree func(t *model.Torrent) int64 `expr:"torrent_disk_free"` // free space of the disk the torrent is saved on
+
+	// time helpers, e.g. age(#) > days(30) or older_than(.last_activity, "2w")
+	Age       func(t *model.Torrent) time.Duration `expr:"age"`  // since added_time
+	Idle      func(t *model.Torrent) time.Duration `expr:"idle"` // since last_activity
+	Days      func(n float64) time.Duration        `expr:"days"`
+	Hours     func(n float64) time.Duration        `expr:"hours"`
+	OlderThan func(v any, d string) (bool, error)  `expr:"older_than"` // a torrent or a time, d accepts "d" and "w" units
+	Between   func(from, to int) bool              `expr:"between"`    // current hour in [from, to), e.g. between(22, 6)
+
+	// aggregate helpers, e.g. count_by_tracker(all_torrents)[host] > 500,
+	// compare torrents by hash as in .hash in map(top_n(torrents, 3, "size"), .hash)
+	GroupBy        func(ts []any, key string) (map[string][]any, error) `expr:"group_by"` // by category, tracker, tag, status or group
+	TopN           func(ts []any, n int, key string) ([]any, error)     `expr:"top_n"`    // largest n by key, "-key" for the smallest
+	SumSize        func(ts []any) (int64, error)                        `expr:"sum_size"`
+	CountByTracker func(ts []any) (map[string]int, error)               `expr:"count_by_tracker"`
+}
+
+func newFuncs(now time.Time, options RunOptions) funcs {
+	clock := clock{now}
+	return funcs{
+		Bytes: utils.ParseBytes,
+		Cmp:   cmp.Compare[int64],
+
+		TrackerUnregistered: newTrackerMatcher(options.TrackerMessages).Unregistered,
+		TrackerError:        trackerError,
+		TrackerWorking:      trackerWorking,
+
+		TrackerStatus: model.TrackerStatuses,
+
+		TorrentDiskFree: torrentDiskFree(options),
+
+		Age:       clock.Age,
+		Idle:      clock.Idle,
+		Days:      days,
+		Hours:     hours,
+		OlderThan: clock.OlderThan,
+		Between:   clock.Between,
+
+		GroupBy:        groupBy,
+		TopN:           topN,
+		SumSize:        sumSize,
+		CountByTracker: countByTracker,
+	}
+}
+
+// torrentDiskFree falls back to the strategy's free space when disks aren't checked per torrent.
+func torrentDiskFree(options RunOptions) func(t *model.Torrent) int64 {
+	if options.DiskFree != nil {
+		return options.DiskFree
+	}
+	return func(*model.Torrent) int64 { return options.Disk }
 }
 
 type RunOptions struct {
@@ -37,13 +117,38 @@ type RunOptions struct {
 	Interval     time.Duration
 	Disk         int64
 	WantSpace    int64
-	Limit        model.Bytes
+	Limits       model.Limits
 	Action       string
+	AddTags      []string
+	Steps        []model.Step
+	GracePeriod  model.GracePeriod
+	State        *state.Bucket
 	SessionStats model.SessionStats
+	AllTorrents  []*model.Torrent // defaults to the torrents passed to Run
+	Match        *vm.Program      // see CompileMatch
+	Script       *Script          // see CompileScript
+	Score        *vm.Program      // see CompileScore
+	ScoreLimit   int              // act on at most this many of the lowest scoring torrents, 0 is unlimited
+
+	DiskFree        func(t *model.Torrent) int64 // free space of a torrent's disk, defaults to Disk
+	CrossSeed       *CrossSeed                   // nil removes torrents without looking at other clients
+	TrackerMessages model.TrackerMessages
+}
+
+func Compile(raw string, client client.Client, macros *Macros) (*vm.Program, error) {
+	return compile(raw, env{}, macros, expr.AsKind(reflect.Slice))
 }
 
-func Compile(raw string, client client.Client) (*vm.Program, error) {
-	prog, err := expr.Compile(raw, expr.Env(env{}), expr.AsKind(reflect.Slice), expr.Patch(cmpPatcher{}))
+func compile(raw string, env any, macros *Macros, options ...expr.Option) (*vm.Program, error) {
+	if macros == nil {
+		macros = &Macros{}
+	}
+	patcher := &macroPatcher{macros: macros}
+
+	prog, err := expr.Compile(raw, append([]expr.Option{expr.Env(env), expr.Patch(patcher), expr.Patch(cmpPatcher{})}, options...)...)
+	if patcher.err != nil {
+		return nil, patcher.err
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -56,13 +161,34 @@ func New(prog *vm.Program, client client.Client) *RemoveExpr {
 }
 
 func (x *RemoveExpr) Run(ctx context.Context, torrents []*model.Torrent, name string, options RunOptions) error {
+	now := time.Now()
+	if options.AllTorrents == nil {
+		options.AllTorrents = torrents
+	}
+	allTorrents := utils.SlicesMap(options.AllTorrents, func(tor *model.Torrent) any { return any(tor) })
+	funcs := newFuncs(now, options)
+	tenv := torrentEnv{
+		AllTorrents:  allTorrents,
+		Disk:         options.Disk,
+		WantSpace:    options.WantSpace,
+		SessionStats: options.SessionStats,
+		funcs:        funcs,
+	}
+
+	if options.Match != nil {
+		var err error
+		if torrents, err = matchTorrents(torrents, tenv, options.Match); err != nil {
+			return err
+		}
+	}
+
 	env := env{
 		Torrents:     utils.SlicesMap(torrents, func(tor *model.Torrent) any { return any(tor) }),
+		AllTorrents:  allTorrents,
 		Disk:         options.Disk,
 		SessionStats: options.SessionStats,
-		Bytes:        utils.ParseBytes,
 		WantSpace:    options.WantSpace,
-		Cmp:          cmp.Compare[int64],
+		funcs:        funcs,
 	}
 	fti, err := expr.Run(x.prog, env)
 	if err != nil {
@@ -83,14 +209,110 @@ func (x *RemoveExpr) Run(ctx context.Context, torrents []*model.Torrent, name st
 		ft = append(ft, t)
 	}
 
+	if options.Script != nil {
+		if ft, err = options.Script.selectTorrents(ctx, ft, name, options); err != nil {
+			return err
+		}
+	}
+
+	var scores map[string]float64
+	if options.Score != nil {
+		if ft, scores, err = selectByScore(ft, tenv, options); err != nil {
+			return err
+		}
+	}
+
+	if options.State == nil && (len(options.Steps) > 0 || !options.GracePeriod.IsZero()) {
+		return fmt.Errorf("chained actions and grace periods need a state store")
+	}
+
+	var entries map[string]*state.Entry
+	if options.State != nil {
+		entries = options.State.Track(utils.SlicesMap(ft, func(t *model.Torrent) string { return t.Hash }), now, options.GracePeriod.Runs)
+	}
+
+	if !options.GracePeriod.IsZero() {
+		ft = utils.SlicesFilter(func(t *model.Torrent) bool {
+			e := entries[t.Hash]
+			if !options.GracePeriod.Elapsed(now.Sub(e.FirstMatched), e.Runs) {
+				slog.Debug("torrent is within grace period", "strategy", name, "hash", t.Hash, "name", t.Name,
+					"first_matched", e.FirstMatched, "runs", e.Runs)
+				return false
+			}
+			return true
+		}, ft)
+	}
+
+	if len(options.Steps) > 0 {
+		return x.runSteps(ctx, ft, entries, scores, now, name, options)
+	}
+
 	if len(ft) < 1 {
 		slog.Debug("no matching torrents found", "strategy", name)
 		return nil
 	}
 
 	slog.Info("running torrent actions", "strategy", name)
+	logTorrents(ft, name, scores)
+
+	if options.DryRun {
+		slog.Debug("dry-run ended", "strategy", name)
+		return nil
+	}
+
+	return x.apply(ctx, ft, name, options)
+}
+
+// runSteps runs chained actions, each step only applies to torrents that have
+// matched continuously for the step's delay and completed every previous step.
+func (x *RemoveExpr) runSteps(ctx context.Context, ft []*model.Torrent, entries map[string]*state.Entry, scores map[string]float64, now time.Time, name string, options RunOptions) error {
+	if len(ft) < 1 {
+		slog.Debug("no matching torrents found", "strategy", name)
+		return nil
+	}
+
+	for i, step := range options.Steps {
+		due := utils.SlicesFilter(func(t *model.Torrent) bool {
+			e := entries[t.Hash]
+			return e.Step == i && now.Sub(e.FirstMatched) >= time.Duration(step.Delay)
+		}, ft)
+		if len(due) < 1 {
+			continue
+		}
+
+		slog.Info("running torrent actions", "strategy", name, "step", i, "action", step.Action)
+		logTorrents(due, name, scores)
+
+		if options.DryRun {
+			continue
+		}
+
+		stepOptions := options
+		stepOptions.Action = step.Action
+		stepOptions.AddTags = step.AddTags
+		if err := x.apply(ctx, due, name, stepOptions); err != nil {
+			return fmt.Errorf("step %d: %w", i, err)
+		}
+
+		for _, t := range due {
+			if i == len(options.Steps)-1 {
+				options.State.Forget(t.Hash)
+			} else {
+				options.State.Advance(t.Hash, i)
+			}
+		}
+	}
+
+	if options.DryRun {
+		slog.Debug("dry-run ended", "strategy", name)
+	}
+	return nil
+}
+
+// logTorrents logs the torrents about to be acted on, scores is nil without score_expr.
+func logTorrents(ft []*model.Torrent, name string, scores map[string]float64) {
 	for _, t := range ft {
-		slog.Info("found eligible torrent",
+		args := []any{
 			"strategy", name,
 			"hash", t.Hash,
 			"name", t.Name,
@@ -105,20 +327,46 @@ func (x *RemoveExpr) Run(ctx context.Context, torrents []*model.Torrent, name st
 			"category", t.Category,
 			"tags", t.Tags,
 			"trackers", t.Trackers,
-		)
-	}
-
-	if options.DryRun {
-		slog.Debug("dry-run ended", "strategy", name)
-		return nil
+		}
+		if score, ok := scores[t.Hash]; ok {
+			args = append(args, "score", score)
+		}
+		slog.Info("found eligible torrent", args...)
 	}
+}
 
+func (x *RemoveExpr) apply(ctx context.Context, ft []*model.Torrent, name string, options RunOptions) error {
 	switch options.Action {
+	case "recheck":
+		if err := x.c.RecheckTorrents(ctx, ft); err != nil {
+			return fmt.Errorf("c.RecheckTorrents: %v", err)
+		}
+		slog.Info("torrents rechecked", "strategy", name, "filtered", len(ft))
+	case "force_start":
+		if err := x.c.ForceStartTorrents(ctx, ft); err != nil {
+			return fmt.Errorf("c.ForceStartTorrents: %v", err)
+		}
+		slog.Info("torrents force started", "strategy", name, "filtered", len(ft))
+	case "reannounce":
+		if err := x.c.ReannounceTorrents(ctx, ft); err != nil {
+			return fmt.Errorf("c.ReannounceTorrents: %v", err)
+		}
+		slog.Info("torrents reannounced", "strategy", name, "filtered", len(ft))
+	case "tag":
+		if err := x.c.TagTorrents(ctx, ft, options.AddTags); err != nil {
+			return fmt.Errorf("c.TagTorrents: %v", err)
+		}
+		slog.Info("torrents tagged", "strategy", name, "filtered", len(ft), "tags", options.AddTags)
 	case "throttle":
-		if err := x.c.ThrottleTorrents(ctx, ft, options.Limit); err != nil {
+		if err := x.c.ThrottleTorrents(ctx, ft, options.Limits); err != nil {
+			return fmt.Errorf("c.ThrottleTorrents: %v", err)
+		}
+		slog.Info("torrents throttled", "strategy", name, "filtered", len(ft), "limits", options.Limits)
+	case "unthrottle":
+		if err := x.c.ThrottleTorrents(ctx, ft, model.NoLimits); err != nil {
 			return fmt.Errorf("c.ThrottleTorrents: %v", err)
 		}
-		slog.Info("torrents throttled", "strategy", name, "filtered", len(ft), "limit", options.Limit)
+		slog.Info("torrents unthrottled", "strategy", name, "filtered", len(ft))
 	case "resume":
 		if err := x.c.ResumeTorrents(ctx, ft); err != nil {
 			return fmt.Errorf("c.ResumeTorrents: %v", err)
@@ -129,13 +377,20 @@ func (x *RemoveExpr) Run(ctx context.Context, torrents []*model.Torrent, name st
 			return fmt.Errorf("c.PauseTorrents: %v", err)
 		}
 		slog.Info("torrents paused", "strategy", name, "filtered", len(ft))
-	case "remove":
-		fallthrough
-	default:
+	case "", "remove":
+		if options.CrossSeed != nil {
+			if err := options.CrossSeed.delete(ctx, x.c, ft, name, options); err != nil {
+				return fmt.Errorf("c.DeleteTorrents: %v", err)
+			}
+			slog.Info("torrents deleted", "strategy", name, "filtered", len(ft), "deleteFiles", options.DeleteFiles, "cross_seed", options.CrossSeed.Mode)
+			return nil
+		}
 		if err := x.c.DeleteTorrents(ctx, ft, name, options.Reannounce, options.DeleteFiles, options.Interval); err != nil {
 			return fmt.Errorf("c.DeleteTorrents: %v", err)
 		}
 		slog.Info("torrents deleted", "strategy", name, "filtered", len(ft), "deleteFiles", options.DeleteFiles)
+	default:
+		return fmt.Errorf("unknown action %q", options.Action)
 	}
 	return nil
 }
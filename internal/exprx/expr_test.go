@@ -2,10 +2,16 @@ package exprx
 
 import (
 	"context"
+	"fmt"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/swkisdust/torrentremover/internal/client"
+	"github.com/swkisdust/torrentremover/internal/state"
+	"github.com/swkisdust/torrentremover/internal/utils"
 	"github.com/swkisdust/torrentremover/model"
 )
 
@@ -67,7 +73,27 @@ func (c *mockClient) ResumeTorrents(ctx context.Context, torrents []*model.Torre
 	return nil
 }
 
-func (c *mockClient) ThrottleTorrents(ctx context.Context, torrents []*model.Torrent, limit model.Bytes) error {
+func (c *mockClient) RecheckTorrents(ctx context.Context, torrents []*model.Torrent) error {
+	c.t.Logf("received torrents %v", torrents)
+	return nil
+}
+
+func (c *mockClient) ForceStartTorrents(ctx context.Context, torrents []*model.Torrent) error {
+	c.t.Logf("received torrents %v", torrents)
+	return nil
+}
+
+func (c *mockClient) ReannounceTorrents(ctx context.Context, torrents []*model.Torrent) error {
+	c.t.Logf("received torrents %v", torrents)
+	return nil
+}
+
+func (c *mockClient) TagTorrents(ctx context.Context, torrents []*model.Torrent, tags []string) error {
+	c.t.Logf("received torrents %v", torrents)
+	return nil
+}
+
+func (c *mockClient) ThrottleTorrents(ctx context.Context, torrents []*model.Torrent, limits model.Limits) error {
 	c.t.Logf("received torrents %v", torrents)
 	return nil
 }
@@ -88,12 +114,16 @@ func (c *mockClient) SessionStats(ctx context.Context) (model.SessionStats, erro
 	return model.SessionStats{}, nil
 }
 
+func (c *mockClient) Ping(ctx context.Context) error {
+	return nil
+}
+
 func TestRemoveExpr(t *testing.T) {
 	t.Run("SimpleExpr", func(t *testing.T) {
 		const exprStr = `filter(torrents, .size > 10240000 && .seeding_time > duration("1h"))`
 		client := &mockClient{t, testCases[1:3]}
 
-		prog, err := Compile(exprStr, client)
+		prog, err := Compile(exprStr, client, nil)
 		if err != nil {
 			t.Errorf("failed to compile expr: %v", err)
 		}
@@ -111,7 +141,7 @@ func TestRemoveExpr(t *testing.T) {
 		const exprStr = `filter(torrents, .seeder / .leecher < 1 && now() - .last_activity > duration("1h"))`
 		client := &mockClient{t, testCases[2:3]}
 
-		prog, err := Compile(exprStr, client)
+		prog, err := Compile(exprStr, client, nil)
 		if err != nil {
 			t.Errorf("failed to compile expr: %v", err)
 		}
@@ -125,3 +155,517 @@ func TestRemoveExpr(t *testing.T) {
 		}
 	})
 }
+
+func TestRemoveExprSteps(t *testing.T) {
+	const exprStr = `filter(torrents, .seeding_time > duration("1h"))`
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("failed to open state: %v", err)
+	}
+	bucket := store.Bucket("test/testSt")
+
+	client := &mockClient{t, nil}
+	prog, err := Compile(exprStr, client, nil)
+	if err != nil {
+		t.Fatalf("failed to compile expr: %v", err)
+	}
+
+	steps := []model.Step{
+		{Action: "pause"},
+		{Action: "remove", Delay: model.Duration(time.Hour)},
+	}
+
+	// the remove step isn't due yet, DeleteTorrents must not be called
+	expr := New(prog, client)
+	if err := expr.Run(context.Background(), testCases, "testSt", RunOptions{Steps: steps, State: bucket}); err != nil {
+		t.Fatalf("failed to execute expr: %v", err)
+	}
+
+	entries := bucket.Track([]string{"test1", "test2", "test3"}, time.Now(), 0)
+	for hash, e := range entries {
+		if e.Step != 1 {
+			t.Errorf("expected %s to complete the first step, got step %d", hash, e.Step)
+		}
+		e.FirstMatched = e.FirstMatched.Add(-2 * time.Hour)
+	}
+
+	client.expected = testCases
+	if err := expr.Run(context.Background(), testCases, "testSt", RunOptions{Steps: steps, State: bucket}); err != nil {
+		t.Fatalf("failed to execute expr: %v", err)
+	}
+
+	if entries := bucket.Track(nil, time.Now(), 0); len(entries) != 0 {
+		t.Errorf("expected removed torrents to be forgotten, got %v", entries)
+	}
+}
+
+func TestRemoveExprGracePeriod(t *testing.T) {
+	const exprStr = `filter(torrents, .ratio > 1)`
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("failed to open state: %v", err)
+	}
+	bucket := store.Bucket("test/testSt")
+
+	client := &mockClient{t, nil}
+	prog, err := Compile(exprStr, client, nil)
+	if err != nil {
+		t.Fatalf("failed to compile expr: %v", err)
+	}
+
+	expr := New(prog, client)
+	options := RunOptions{GracePeriod: model.GracePeriod{Runs: 2}, State: bucket}
+
+	// first match, still within the grace period
+	if err := expr.Run(context.Background(), testCases, "testSt", options); err != nil {
+		t.Fatalf("failed to execute expr: %v", err)
+	}
+
+	// test2 stops matching while test1 reaches the grace period
+	client.expected = testCases[:1]
+	if err := expr.Run(context.Background(), testCases[:1], "testSt", options); err != nil {
+		t.Fatalf("failed to execute expr: %v", err)
+	}
+
+	// test2 has to start over
+	if err := expr.Run(context.Background(), testCases, "testSt", options); err != nil {
+		t.Fatalf("failed to execute expr: %v", err)
+	}
+}
+
+func TestRemoveExprTimeHelpers(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		expected []*model.Torrent
+	}{
+		{
+			name:     "Idle",
+			expr:     `filter(torrents, idle(#) > hours(1))`,
+			expected: []*model.Torrent{testCases[0], testCases[2]},
+		},
+		{
+			name:     "OlderThan",
+			expr:     `filter(torrents, older_than(.last_activity, "3h") && idle(#) < days(0.5))`,
+			expected: testCases[2:3],
+		},
+		{
+			name:     "OlderThanDays",
+			expr:     `filter(torrents, .seeding_time > days(1) && older_than(#, "1w2d"))`,
+			expected: testCases[1:2],
+		},
+		{
+			name:     "Between",
+			expr:     `filter(torrents, between(0, 24) && !between(0, 0))`,
+			expected: testCases,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockClient{t, tt.expected}
+
+			prog, err := Compile(tt.expr, client, nil)
+			if err != nil {
+				t.Fatalf("failed to compile expr: %v", err)
+			}
+
+			if err := New(prog, client).Run(context.Background(), testCases, "testSt", RunOptions{}); err != nil {
+				t.Errorf("failed to execute expr: %v", err)
+			}
+		})
+	}
+}
+
+func TestClock(t *testing.T) {
+	night := clock{time.Date(2024, 1, 1, 23, 30, 0, 0, time.Local)}
+	if !night.Between(22, 6) {
+		t.Error("expected 23:30 to be between 22 and 6")
+	}
+	if night.Between(8, 23) {
+		t.Error("expected 23:30 not to be between 8 and 23")
+	}
+
+	torrent := &model.Torrent{AddedTime: night.now.Add(-31 * 24 * time.Hour)}
+	if idle := night.Idle(torrent); idle != 31*24*time.Hour {
+		t.Errorf("expected a torrent without activity to be idle since added, got %v", idle)
+	}
+	if ok, err := night.OlderThan(torrent, "30d"); err != nil || !ok {
+		t.Errorf("expected torrent to be older than 30d, got %v, %v", ok, err)
+	}
+	if ok, err := night.OlderThan(torrent, "4w4d"); err != nil || ok {
+		t.Errorf("expected torrent not to be older than 4w4d, got %v, %v", ok, err)
+	}
+	if _, err := night.OlderThan(torrent, "30x"); err == nil {
+		t.Error("expected an error for an unknown unit")
+	}
+}
+
+func TestRemoveExprAggregates(t *testing.T) {
+	now := time.Now()
+	trackers := func(urls ...string) []model.TorrentTracker {
+		return utils.SlicesMap(urls, func(url string) model.TorrentTracker { return model.TorrentTracker{URL: url} })
+	}
+	all := []*model.Torrent{
+		{Hash: "a1", Category: "movies", Size: 100, AddedTime: now.Add(-3 * time.Hour), Trackers: trackers("https://a.example/announce")},
+		{Hash: "a2", Category: "movies", Size: 200, AddedTime: now.Add(-2 * time.Hour), Trackers: trackers("https://a.example/announce")},
+		{Hash: "a3", Category: "movies", Size: 300, AddedTime: now.Add(-1 * time.Hour), Trackers: trackers("https://a.example/announce")},
+		{Hash: "b1", Category: "tv", Size: 400, AddedTime: now.Add(-4 * time.Hour), Trackers: trackers("https://b.example/announce")},
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		torrents []*model.Torrent
+		expected []*model.Torrent
+	}{
+		{
+			name:     "KeepNewestPerCategory",
+			expr:     `filter(torrents, .hash not in map(top_n(group_by(all_torrents, "category")[.category], 2, "added_time"), .hash))`,
+			torrents: all,
+			expected: all[:1],
+		},
+		{
+			name:     "OldestFirst",
+			expr:     `top_n(torrents, 2, "-added_time")`,
+			torrents: all,
+			expected: []*model.Torrent{all[3], all[0]},
+		},
+		{
+			name:     "CountByTracker",
+			expr:     `filter(torrents, count_by_tracker(all_torrents)["a.example"] > 2)`,
+			torrents: all[3:],
+			expected: all[3:],
+		},
+		{
+			name:     "SumSize",
+			expr:     `sum_size(all_torrents) == 1000 ? torrents : []`,
+			torrents: all[:1],
+			expected: all[:1],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockClient{t, tt.expected}
+
+			prog, err := Compile(tt.expr, client, nil)
+			if err != nil {
+				t.Fatalf("failed to compile expr: %v", err)
+			}
+
+			if err := New(prog, client).Run(context.Background(), tt.torrents, "testSt", RunOptions{AllTorrents: all}); err != nil {
+				t.Errorf("failed to execute expr: %v", err)
+			}
+		})
+	}
+
+	if _, err := groupBy([]any{all[0]}, "ratio"); err == nil {
+		t.Error("expected an error for an unsupported group key")
+	}
+}
+
+func TestRemoveExprScore(t *testing.T) {
+	const scoreStr = `torrent.ratio * 10 + torrent.seeder`
+	score, err := CompileScore(scoreStr, nil)
+	if err != nil {
+		t.Fatalf("failed to compile score expr: %v", err)
+	}
+
+	// scores: test1 140.34, test2 26, test3 28.5
+	tests := []struct {
+		name     string
+		options  RunOptions
+		expected []*model.Torrent
+	}{
+		{
+			name:     "Unlimited",
+			options:  RunOptions{},
+			expected: []*model.Torrent{testCases[1], testCases[2], testCases[0]},
+		},
+		{
+			name:     "ScoreLimit",
+			options:  RunOptions{ScoreLimit: 1},
+			expected: testCases[1:2],
+		},
+		{
+			name:     "WantSpace",
+			options:  RunOptions{Disk: 1024, WantSpace: 5368709120 + 2048},
+			expected: testCases[1:3],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockClient{t, tt.expected}
+			prog, err := Compile(`torrents`, client, nil)
+			if err != nil {
+				t.Fatalf("failed to compile expr: %v", err)
+			}
+
+			tt.options.Score = score
+			if err := New(prog, client).Run(context.Background(), testCases, "testSt", tt.options); err != nil {
+				t.Errorf("failed to execute expr: %v", err)
+			}
+		})
+	}
+
+	if _, err := CompileScore(`torrent.name`, nil); err == nil {
+		t.Error("expected a non-numeric score expr to fail to compile")
+	}
+}
+
+func TestRemoveExprMatch(t *testing.T) {
+	client := &mockClient{t, testCases[1:3]}
+
+	match, err := CompileMatch(`size > 10240000 && seeding_time > duration("1h") && !tracker_error(torrent)`, nil)
+	if err != nil {
+		t.Fatalf("failed to compile match expr: %v", err)
+	}
+	prog, err := Compile(`torrents`, client, nil)
+	if err != nil {
+		t.Fatalf("failed to compile expr: %v", err)
+	}
+
+	if err := New(prog, client).Run(context.Background(), testCases, "testSt", RunOptions{Match: match}); err != nil {
+		t.Errorf("failed to execute expr: %v", err)
+	}
+
+	for _, raw := range []string{`ratio`, `ratio > "2"`, `filter(torrents, .ratio > 2)`, `unknown > 1`} {
+		if _, err := CompileMatch(raw, nil); err == nil {
+			t.Errorf("expected %q to fail to compile", raw)
+		}
+	}
+}
+
+func TestRemoveExprTorrentDiskFree(t *testing.T) {
+	free := map[string]int64{"test1": 1024, "test2": 4096, "test3": 1024}
+	client := &mockClient{t, []*model.Torrent{testCases[0], testCases[2]}}
+
+	prog, err := Compile(`filter(torrents, torrent_disk_free(#) < want_space)`, client, nil)
+	if err != nil {
+		t.Fatalf("failed to compile expr: %v", err)
+	}
+
+	options := RunOptions{
+		WantSpace: 2048,
+		DiskFree:  func(t *model.Torrent) int64 { return free[t.Hash] },
+	}
+	if err := New(prog, client).Run(context.Background(), testCases, "testSt", options); err != nil {
+		t.Errorf("failed to execute expr: %v", err)
+	}
+
+	// without per torrent disks every torrent reports the strategy's free space
+	client.expected = testCases
+	if err := New(prog, client).Run(context.Background(), testCases, "testSt", RunOptions{Disk: 1024, WantSpace: 2048}); err != nil {
+		t.Errorf("failed to execute expr: %v", err)
+	}
+}
+
+func TestMacros(t *testing.T) {
+	macros, err := ParseMacros(map[string]string{
+		"min_ratio":      `1`,
+		"seeded(t, min)": `t.ratio > min`,
+		"long_seeded":    `.seeding_time > duration("1h")`,
+		"hnr_ok":         `ratio > min_ratio && seeding_time > duration("1h")`,
+	})
+	if err != nil {
+		t.Fatalf("failed to parse macros: %v", err)
+	}
+
+	client := &mockClient{t, testCases[1:2]}
+	prog, err := Compile(`filter(torrents, seeded(#, min_ratio * 2) && long_seeded)`, client, macros)
+	if err != nil {
+		t.Fatalf("failed to compile expr: %v", err)
+	}
+	if err := New(prog, client).Run(context.Background(), testCases, "testSt", RunOptions{}); err != nil {
+		t.Errorf("failed to execute expr: %v", err)
+	}
+
+	client.expected = testCases[:2]
+	match, err := CompileMatch(`hnr_ok`, macros)
+	if err != nil {
+		t.Fatalf("failed to compile match expr: %v", err)
+	}
+	if prog, err = Compile(`torrents`, client, macros); err != nil {
+		t.Fatalf("failed to compile expr: %v", err)
+	}
+	if err := New(prog, client).Run(context.Background(), testCases, "testSt", RunOptions{Match: match}); err != nil {
+		t.Errorf("failed to execute expr: %v", err)
+	}
+
+	if _, err := Compile(`filter(torrents, seeded(#))`, client, macros); err == nil || !strings.Contains(err.Error(), `macro "seeded"`) {
+		t.Errorf("expected an argument count error naming the macro, got %v", err)
+	}
+
+	if _, err := ParseMacros(map[string]string{"a": `b > 1`, "b": `c`, "c": `a`}); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected a cycle error, got %v", err)
+	}
+
+	tests := map[string]map[string]string{
+		"self":      {"a": `a`},
+		"syntax":    {"a": `ratio >`},
+		"bad name":  {"a-b": `1`},
+		"duplicate": {"a": `1`, "a()": `2`},
+		"type":      {"a": `ratio > "1"`},
+		"unknown":   {"a": `ratoi > 1`},
+		"closure":   {"a": `.ratio > 1 && size(.name)`},
+		"arguments": {"a(t)": `t.ratio > 1`, "b": `a(1, 2)`},
+	}
+	for name, raw := range tests {
+		if _, err := ParseMacros(raw); err == nil || !strings.Contains(err.Error(), `macro "a`) {
+			t.Errorf("%s: expected an error naming the macro, got %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"size", "torrents", "bytes", "len", "filter", "true"} {
+		if _, err := ParseMacros(map[string]string{name: `1`}); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("macro %q", name)) {
+			t.Errorf("%s: expected a name collision error, got %v", name, err)
+		}
+	}
+}
+
+func TestRemoveExprScript(t *testing.T) {
+	const src = `
+def select(torrents, env):
+    total = 0
+    hashes = []
+    for t in sorted(torrents, key=lambda t: t.ratio, reverse=True):
+        if total >= env.want_space - env.disk:
+            break
+        total += t.size
+        hashes.append(t.hash)
+    return hashes
+`
+	script, err := CompileScript("testSt", src, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to compile script: %v", err)
+	}
+
+	client := &mockClient{t, []*model.Torrent{testCases[1]}}
+	prog, err := Compile(`torrents`, client, nil)
+	if err != nil {
+		t.Fatalf("failed to compile expr: %v", err)
+	}
+	if err := New(prog, client).Run(context.Background(), testCases, "testSt", RunOptions{
+		Script:    script,
+		Disk:      1024,
+		WantSpace: 2048,
+	}); err != nil {
+		t.Errorf("failed to execute script: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		src        string
+		compileErr string // expected CompileScript error, empty if it should compile
+		runErr     string // expected Run error otherwise
+	}{
+		{"syntax", "def select(torrents, env)\n    return []\n", "want ':'", ""},
+		{"undefined", "def select(torrents, env):\n    return os.listdir()\n", "undefined: os", ""},
+		{"steps", "def select(torrents, env):\n    while True:\n        pass\n", "", "too many steps"},
+		{"no select", "x = 1\n", "", "select(torrents, env) is not defined"},
+		{"bad type", "def select(torrents, env):\n    return 1\n", "", "select returned int"},
+		{"load", "load('os.star', 'os')\ndef select(torrents, env):\n    return []\n", "", "load not implemented"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, err := CompileScript("testSt", tt.src, time.Second, 10000)
+			if tt.compileErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.compileErr) {
+					t.Fatalf("expected compile error containing %q, got %v", tt.compileErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to compile script: %v", err)
+			}
+
+			err = New(prog, client).Run(context.Background(), testCases, "testSt", RunOptions{Script: script})
+			if err == nil || !strings.Contains(err.Error(), tt.runErr) {
+				t.Errorf("expected run error containing %q, got %v", tt.runErr, err)
+			}
+		})
+	}
+}
+
+// deleteRecorder records which torrents were deleted and whether their files were.
+type deleteRecorder struct {
+	mockClient
+	deleted map[string]bool
+}
+
+func (c *deleteRecorder) DeleteTorrents(ctx context.Context, torrents []*model.Torrent, name string, reannounce, deleteFiles bool, interval time.Duration) error {
+	for _, t := range torrents {
+		c.deleted[t.Hash] = deleteFiles
+	}
+	return nil
+}
+
+func TestRemoveExprCrossSeed(t *testing.T) {
+	public := []*model.Torrent{
+		{Hash: "a", ContentPath: "/data/a"},
+		{Hash: "b", ContentPath: "/data/b"},
+	}
+	private := []*model.Torrent{
+		{Hash: "a2", ContentPath: "/data/a"},
+	}
+
+	prog, err := Compile(`torrents`, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to compile expr: %v", err)
+	}
+
+	tests := []struct {
+		mode            string
+		public, private map[string]bool
+	}{
+		{model.CrossSeedKeepFiles, map[string]bool{"a": false, "b": true}, map[string]bool{}},
+		{model.CrossSeedTogether, map[string]bool{"a": true, "b": true}, map[string]bool{"a2": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			pub := &deleteRecorder{mockClient{t: t}, map[string]bool{}}
+			priv := &deleteRecorder{mockClient{t: t}, map[string]bool{}}
+			cs := &CrossSeed{
+				Mode:    tt.mode,
+				Client:  "public",
+				Clients: map[string]client.Client{"public": pub, "private": priv},
+				Index:   model.NewIndex(map[string][]*model.Torrent{"public": public, "private": private}),
+			}
+
+			if err := New(prog, pub).Run(context.Background(), public, "testSt", RunOptions{DeleteFiles: true, CrossSeed: cs}); err != nil {
+				t.Fatalf("failed to execute expr: %v", err)
+			}
+			if !reflect.DeepEqual(pub.deleted, tt.public) {
+				t.Errorf("public deleted %v, want %v", pub.deleted, tt.public)
+			}
+			if !reflect.DeepEqual(priv.deleted, tt.private) {
+				t.Errorf("private deleted %v, want %v", priv.deleted, tt.private)
+			}
+		})
+	}
+}
+
+func TestRemoveExprUnknownAction(t *testing.T) {
+	client := &failOnCall{mockClient{t: t}}
+	prog, err := Compile(`torrents`, client, nil)
+	if err != nil {
+		t.Fatalf("failed to compile expr: %v", err)
+	}
+
+	err = New(prog, client).Run(context.Background(), testCases, "testSt", RunOptions{Action: "puase"})
+	if err == nil || !strings.Contains(err.Error(), `unknown action "puase"`) {
+		t.Errorf("expected an unknown action error, got %v", err)
+	}
+}
+
+// failOnCall fails the test if torrents are deleted.
+type failOnCall struct {
+	mockClient
+}
+
+func (c *failOnCall) DeleteTorrents(ctx context.Context, torrents []*model.Torrent, name string, reannounce, deleteFiles bool, interval time.Duration) error {
+	c.t.Errorf("unexpected deletion of %v", torrents)
+	return nil
+}
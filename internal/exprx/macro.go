@@ -0,0 +1,231 @@
+package exprx
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/parser"
+)
+
+// Macros are named expressions shared by every strategy, a macro is inlined
+// wherever its name is used, so the same macro works in expr, match and score_expr
+// as long as it is written for that context (e.g. .ratio inside filter, ratio in match).
+// A macro may take parameters, declared in its name: `is_public(t)`.
+type Macros struct {
+	macros map[string]*macro
+}
+
+type macro struct {
+	name   string
+	params []string
+	body   string
+}
+
+var macroNameRe = regexp.MustCompile(`^\s*([A-Za-z_]\w*)\s*(?:\(([^)]*)\))?\s*$`)
+
+// ParseMacros parses every macro, rejects macros that reference each other in a cycle
+// or shadow an identifier of the envs, and type-checks every body, see Macros.check.
+func ParseMacros(raw map[string]string) (*Macros, error) {
+	m := &Macros{macros: make(map[string]*macro, len(raw))}
+	for key, body := range raw {
+		match := macroNameRe.FindStringSubmatch(key)
+		if match == nil {
+			return nil, fmt.Errorf("macro %q: invalid name", key)
+		}
+
+		mac := &macro{name: match[1], body: body}
+		if params := strings.TrimSpace(match[2]); params != "" {
+			for p := range strings.SplitSeq(params, ",") {
+				mac.params = append(mac.params, strings.TrimSpace(p))
+			}
+		}
+		if _, ok := m.macros[mac.name]; ok {
+			return nil, fmt.Errorf("macro %q: defined more than once", mac.name)
+		}
+		if reservedName(mac.name) {
+			return nil, fmt.Errorf("macro %q: name is already defined by the env or a builtin", mac.name)
+		}
+		if _, err := parseMacro(body); err != nil {
+			return nil, fmt.Errorf("macro %q: %w", mac.name, err)
+		}
+		m.macros[mac.name] = mac
+	}
+
+	for _, mac := range m.macros {
+		if err := m.checkCycle(mac, nil); err != nil {
+			return nil, err
+		}
+	}
+	for _, mac := range m.macros {
+		if err := m.check(mac); err != nil {
+			return nil, fmt.Errorf("macro %q: %w", mac.name, err)
+		}
+	}
+
+	return m, nil
+}
+
+// reservedName reports whether name is an identifier of any env or a builtin,
+// which the macro would silently shadow or never replace.
+func reservedName(name string) bool {
+	for _, env := range []any{env{}, torrentEnv{}} {
+		if _, err := expr.Compile(name, expr.Env(env)); err == nil {
+			return true
+		}
+	}
+
+	// builtins like filter and len parse as builtin nodes instead of calls
+	tree, err := parser.Parse(name + "(a, b)")
+	if err != nil {
+		return true
+	}
+	call, ok := tree.Node.(*ast.CallNode)
+	if !ok {
+		return true
+	}
+	_, ok = call.Callee.(*ast.IdentifierNode)
+	return !ok
+}
+
+// macroArg stands in for the parameters of a macro while it's type-checked,
+// its result is untyped so any use of a parameter passes.
+const macroArg = "__macro_arg"
+
+var macroArgFunc = expr.Function(macroArg, func(...any) (any, error) { return nil, nil }, new(func() any))
+
+// check type-checks a macro in the contexts it can be used in, it has to compile in at least one:
+// expr and match/score_expr, or the predicate of a builtin over torrents for bodies using closure members.
+func (m *Macros) check(mac *macro) error {
+	use := mac.name
+	if len(mac.params) > 0 {
+		use += "(" + strings.Repeat(macroArg+"(), ", len(mac.params)-1) + macroArg + "())"
+	}
+
+	if _, err := parser.Parse(mac.body); err != nil {
+		_, err := compile("filter(torrents, "+use+")", env{}, m, macroArgFunc)
+		return err
+	}
+
+	_, exprErr := compile(use, env{}, m, macroArgFunc)
+	if exprErr == nil {
+		return nil
+	}
+	_, torrentErr := compile(use, torrentEnv{}, m, macroArgFunc)
+	if torrentErr == nil {
+		return nil
+	}
+	return fmt.Errorf("in expr: %w; in match or score_expr: %w", exprErr, torrentErr)
+}
+
+func (m *Macros) checkCycle(mac *macro, stack []string) error {
+	if slices.Contains(stack, mac.name) {
+		return fmt.Errorf("macro %q: cycle %s", mac.name, strings.Join(append(stack, mac.name), " -> "))
+	}
+	stack = append(stack, mac.name)
+
+	node, err := parseMacro(mac.body)
+	if err != nil {
+		return fmt.Errorf("macro %q: %w", mac.name, err)
+	}
+
+	var refs identifiers
+	ast.Walk(&node, &refs)
+	for _, ref := range refs {
+		if next, ok := m.macros[ref]; ok && !slices.Contains(mac.params, ref) {
+			if err := m.checkCycle(next, stack); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type identifiers []string
+
+func (ids *identifiers) Visit(node *ast.Node) {
+	if n, ok := (*node).(*ast.IdentifierNode); ok {
+		*ids = append(*ids, n.Value)
+	}
+}
+
+// macroPatcher inlines macros, errors are collected in err
+// since visitors can't return them.
+type macroPatcher struct {
+	macros *Macros
+	params map[string]ast.Node // arguments of the macro being expanded
+	err    error
+}
+
+func (p *macroPatcher) Visit(node *ast.Node) {
+	if p.err != nil {
+		return
+	}
+
+	switch n := (*node).(type) {
+	case *ast.IdentifierNode:
+		if arg, ok := p.params[n.Value]; ok {
+			ast.Patch(node, arg)
+			return
+		}
+		if mac, ok := p.macros.macros[n.Value]; ok && len(mac.params) == 0 {
+			p.expand(node, mac, nil)
+		}
+	case *ast.CallNode:
+		callee, ok := n.Callee.(*ast.IdentifierNode)
+		if !ok {
+			return
+		}
+		if mac, ok := p.macros.macros[callee.Value]; ok {
+			if len(n.Arguments) != len(mac.params) {
+				p.err = fmt.Errorf("macro %q: expected %d arguments, got %d", mac.name, len(mac.params), len(n.Arguments))
+				return
+			}
+			p.expand(node, mac, n.Arguments)
+		}
+	}
+}
+
+func (p *macroPatcher) expand(node *ast.Node, mac *macro, args []ast.Node) {
+	body, err := parseMacro(mac.body)
+	if err != nil {
+		p.err = fmt.Errorf("macro %q: %w", mac.name, err)
+		return
+	}
+
+	sub := &macroPatcher{macros: p.macros, params: make(map[string]ast.Node, len(args))}
+	for i, arg := range args {
+		sub.params[mac.params[i]] = arg
+	}
+	ast.Walk(&body, sub)
+	if sub.err != nil {
+		p.err = sub.err
+		return
+	}
+
+	ast.Patch(node, body)
+}
+
+// parseMacro parses a fresh tree of the macro body on every call, inlined nodes can't be shared.
+// Bodies using closure members like .ratio or # only parse inside a predicate,
+// so they are parsed as the predicate of map([], ...) instead.
+func parseMacro(body string) (ast.Node, error) {
+	tree, err := parser.Parse(body)
+	if err == nil {
+		return tree.Node, nil
+	}
+
+	wrapped, werr := parser.Parse("map([], " + body + "\n)")
+	if werr != nil {
+		return nil, err
+	}
+	if b, ok := wrapped.Node.(*ast.BuiltinNode); ok && len(b.Arguments) == 2 {
+		if pred, ok := b.Arguments[1].(*ast.PredicateNode); ok {
+			return pred.Node, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,31 @@
+package exprx
+
+import (
+	"fmt"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+
+	"github.com/swkisdust/torrentremover/model"
+)
+
+// CompileMatch compiles a match expression, a predicate evaluated once per torrent,
+// e.g. ratio > 2 && seeding_time > duration("168h").
+func CompileMatch(raw string, macros *Macros) (*vm.Program, error) {
+	return compile(raw, torrentEnv{}, macros, expr.AsBool())
+}
+
+func matchTorrents(torrents []*model.Torrent, env torrentEnv, prog *vm.Program) ([]*model.Torrent, error) {
+	matched := make([]*model.Torrent, 0, len(torrents))
+	for _, t := range torrents {
+		env.Torrent, env.Self = t, t
+		v, err := expr.Run(prog, env)
+		if err != nil {
+			return nil, fmt.Errorf("match of %s: %w", t.Hash, err)
+		}
+		if v.(bool) {
+			matched = append(matched, t)
+		}
+	}
+	return matched, nil
+}
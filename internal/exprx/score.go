@@ -0,0 +1,55 @@
+package exprx
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+
+	"github.com/swkisdust/torrentremover/model"
+)
+
+// CompileScore compiles a score_expr, it is evaluated once per torrent
+// and lower scores are acted on first, e.g. ratio * 10 - age(torrent).Hours().
+func CompileScore(raw string, macros *Macros) (*vm.Program, error) {
+	return compile(raw, torrentEnv{}, macros, expr.AsFloat64())
+}
+
+// selectByScore sorts the torrents by score, lowest first, and keeps the ones needed to
+// reach the space target (want_space minus the free disk space) and the score limit.
+func selectByScore(ft []*model.Torrent, env torrentEnv, options RunOptions) ([]*model.Torrent, map[string]float64, error) {
+	scores := make(map[string]float64, len(ft))
+	for _, t := range ft {
+		env.Torrent, env.Self = t, t
+		v, err := expr.Run(options.Score, env)
+		if err != nil {
+			return nil, nil, fmt.Errorf("score of %s: %w", t.Hash, err)
+		}
+		scores[t.Hash] = v.(float64)
+	}
+
+	sorted := slices.Clone(ft)
+	slices.SortStableFunc(sorted, func(a, b *model.Torrent) int {
+		return cmp.Compare(scores[a.Hash], scores[b.Hash])
+	})
+
+	needed := options.WantSpace - options.Disk
+	var selected []*model.Torrent
+	var freed int64
+	for _, t := range sorted {
+		if options.ScoreLimit > 0 && len(selected) >= options.ScoreLimit {
+			break
+		}
+		if options.WantSpace > 0 && freed >= needed {
+			break
+		}
+		selected = append(selected, t)
+		freed += t.Size
+	}
+
+	slog.Debug("selected torrents by score", "selected", len(selected), "candidates", len(ft), "freed", freed)
+	return selected, scores, nil
+}
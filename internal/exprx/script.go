@@ -0,0 +1,211 @@
+package exprx
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+
+	"github.com/swkisdust/torrentremover/internal/utils"
+	"github.com/swkisdust/torrentremover/model"
+)
+
+const (
+	defaultScriptTimeout  = 5 * time.Second
+	defaultScriptMaxSteps = 10_000_000
+)
+
+// Script is a starlark script selecting the torrents to act on, it must define
+//
+//	def select(torrents, env):
+//	    return [t.hash for t in torrents if t.ratio > 2]
+//
+// torrents are the candidates left by the filters and expressions, env holds
+// all_torrents, disk, want_space, stats and now. Times are unix seconds and durations seconds.
+// Scripts can't load modules and are stopped after the timeout or step limit.
+type Script struct {
+	prog     *starlark.Program
+	timeout  time.Duration
+	maxSteps uint64
+}
+
+var scriptBuiltins = starlark.StringDict{
+	"bytes":      starlark.NewBuiltin("bytes", starlarkBytes),
+	"has_status": starlark.NewBuiltin("has_status", starlarkHasStatus),
+}
+
+// CompileScript compiles a script, zero timeout and maxSteps use the defaults.
+func CompileScript(name, src string, timeout time.Duration, maxSteps uint64) (*Script, error) {
+	opts := &syntax.FileOptions{Set: true, While: true, TopLevelControl: true, GlobalReassign: true}
+	_, prog, err := starlark.SourceProgramOptions(opts, name+".star", src, scriptBuiltins.Has)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Script{
+		prog:     prog,
+		timeout:  utils.IfOr(timeout > 0, timeout, defaultScriptTimeout),
+		maxSteps: utils.IfOr(maxSteps > 0, maxSteps, defaultScriptMaxSteps),
+	}, nil
+}
+
+// selectTorrents runs the script and keeps the torrents whose hashes it returned, in its order.
+func (s *Script) selectTorrents(ctx context.Context, ft []*model.Torrent, name string, options RunOptions) ([]*model.Torrent, error) {
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			slog.Debug("script output", "strategy", name, "msg", msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(s.maxSteps)
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { thread.Cancel(ctx.Err().Error()) })
+	defer stop()
+
+	globals, err := s.prog.Init(thread, scriptBuiltins)
+	if err != nil {
+		return nil, fmt.Errorf("script: %w", err)
+	}
+	fn, ok := globals["select"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("script: select(torrents, env) is not defined")
+	}
+
+	env := starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"all_torrents": toStarlarkTorrents(options.AllTorrents),
+		"disk":         starlark.MakeInt64(options.Disk),
+		"want_space":   starlark.MakeInt64(options.WantSpace),
+		"now":          starlark.MakeInt64(time.Now().Unix()),
+		"stats": starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"total_dl_speed": starlark.MakeInt64(options.SessionStats.TotalDlSpeed),
+			"total_up_speed": starlark.MakeInt64(options.SessionStats.TotalUpSpeed),
+		}),
+	})
+
+	v, err := starlark.Call(thread, fn, starlark.Tuple{toStarlarkTorrents(ft), env}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("script: %w", err)
+	}
+
+	iterable, ok := v.(starlark.Iterable)
+	if !ok {
+		return nil, fmt.Errorf("script: select returned %s, expected a list of hashes", v.Type())
+	}
+
+	byHash := make(map[string]*model.Torrent, len(ft))
+	for _, t := range ft {
+		byHash[t.Hash] = t
+	}
+
+	var selected []*model.Torrent
+	iter := iterable.Iterate()
+	defer iter.Done()
+	var item starlark.Value
+	for iter.Next(&item) {
+		hash, ok := starlark.AsString(item)
+		if !ok {
+			return nil, fmt.Errorf("script: select returned %s in the list, expected a hash", item.Type())
+		}
+		t, ok := byHash[hash]
+		if !ok {
+			slog.Warn("script returned a torrent that isn't a candidate", "strategy", name, "hash", hash)
+			continue
+		}
+		delete(byHash, hash)
+		selected = append(selected, t)
+	}
+
+	return selected, nil
+}
+
+func toStarlarkTorrents(torrents []*model.Torrent) *starlark.List {
+	values := make([]starlark.Value, len(torrents))
+	for i, t := range torrents {
+		values[i] = toStarlarkTorrent(t)
+	}
+	return starlark.NewList(values)
+}
+
+func toStarlarkTorrent(t *model.Torrent) *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"hash":          starlark.String(t.Hash),
+		"name":          starlark.String(t.Name),
+		"ratio":         starlark.Float(t.Ratio),
+		"progress":      starlark.Float(t.Progress),
+		"category":      starlark.String(t.Category),
+		"tags":          toStarlarkStrings(t.Tags),
+		"status":        starlark.MakeInt(int(t.Status)),
+		"size":          starlark.MakeInt64(t.Size),
+		"leecher":       starlark.MakeInt64(t.Leecher),
+		"seeder":        starlark.MakeInt64(t.Seeder),
+		"dl_speed":      starlark.MakeInt64(t.DlSpeed),
+		"up_speed":      starlark.MakeInt64(t.UpSpeed),
+		"downloaded":    starlark.MakeInt64(t.Downloaded),
+		"uploaded":      starlark.MakeInt64(t.Uploaded),
+		"added_time":    starlark.MakeInt64(unixOrZero(t.AddedTime)),
+		"last_activity": starlark.MakeInt64(unixOrZero(t.LastActivity)),
+		"seeding_time":  starlark.Float(t.SeedingTime.Seconds()),
+		"time_elapsed":  starlark.Float(t.TimeElapsed.Seconds()),
+		"group":         starlark.String(t.Group),
+		"trackers": starlark.NewList(utils.SlicesMap(t.Trackers, func(tt model.TorrentTracker) starlark.Value {
+			return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+				"url":     starlark.String(tt.URL),
+				"status":  starlark.String(tt.Status.String()),
+				"message": starlark.String(tt.Message),
+			})
+		})),
+	})
+}
+
+func toStarlarkStrings(s []string) *starlark.List {
+	return starlark.NewList(utils.SlicesMap(s, func(e string) starlark.Value { return starlark.String(e) }))
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// bytes("10GiB") parses a size like the expr helper.
+func starlarkBytes(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var s string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+	n, err := utils.ParseBytes(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+	return starlark.MakeInt64(n), nil
+}
+
+// has_status(t, "seeding") reports whether the torrent has the named status.
+func starlarkHasStatus(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var t *starlarkstruct.Struct
+	var name string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &t, &name); err != nil {
+		return nil, err
+	}
+
+	status := model.GetStatus(name)
+	if status == 0 {
+		return nil, fmt.Errorf("%s: unknown status %q", fn.Name(), name)
+	}
+	v, err := t.Attr("status")
+	if err != nil {
+		return nil, err
+	}
+	var code int
+	if err := starlark.AsInt(v, &code); err != nil {
+		return nil, err
+	}
+	return starlark.Bool(model.Status(code).HasFlag(status)), nil
+}
@@ -0,0 +1,65 @@
+package exprx
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/swkisdust/torrentremover/internal/utils"
+	"github.com/swkisdust/torrentremover/model"
+)
+
+// clock backs the time helpers, now is fixed once per run
+// so every torrent is compared against the same instant.
+type clock struct {
+	now time.Time
+}
+
+// Age returns how long ago the torrent was added.
+func (c clock) Age(t *model.Torrent) time.Duration {
+	return c.now.Sub(t.AddedTime)
+}
+
+// Idle returns how long ago the torrent last transferred data,
+// a torrent that never did is idle since it was added.
+func (c clock) Idle(t *model.Torrent) time.Duration {
+	if t.LastActivity.IsZero() {
+		return c.Age(t)
+	}
+	return c.now.Sub(t.LastActivity)
+}
+
+// OlderThan reports whether a torrent (by its added time) or a time
+// lies further in the past than d, d also accepts days and weeks, e.g. "30d" or "2w".
+func (c clock) OlderThan(v any, d string) (bool, error) {
+	duration, err := utils.ParseDuration(d)
+	if err != nil {
+		return false, err
+	}
+
+	switch v := v.(type) {
+	case *model.Torrent:
+		return c.Age(v) > duration, nil
+	case time.Time:
+		return c.now.Sub(v) > duration, nil
+	default:
+		return false, fmt.Errorf("older_than: expected a torrent or a time, got %T", v)
+	}
+}
+
+// Between reports whether the current hour of the day is within [from, to),
+// wrapping around midnight when from is greater than to, e.g. between(22, 6).
+func (c clock) Between(from, to int) bool {
+	hour := c.now.Hour()
+	if from <= to {
+		return hour >= from && hour < to
+	}
+	return hour >= from || hour < to
+}
+
+func days(n float64) time.Duration {
+	return time.Duration(n * float64(24*time.Hour))
+}
+
+func hours(n float64) time.Duration {
+	return time.Duration(n * float64(time.Hour))
+}
@@ -0,0 +1,111 @@
+package exprx
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/swkisdust/torrentremover/internal/utils"
+	"github.com/swkisdust/torrentremover/model"
+)
+
+// Messages trackers send once a torrent is unregistered, deleted or trumped,
+// collected from qBittorrent, Transmission and Deluge announce replies.
+var unregisteredMessages = []string{
+	"unregistered",
+	"not registered",
+	"torrent not found",
+	"torrent is not found",
+	"torrent not exist",
+	"torrent does not exist",
+	"torrent doesn't exist",
+	"torrent existiert nicht",
+	"unknown torrent",
+	"infohash not found",
+	"info hash not found",
+	"torrent has been deleted",
+	"torrent was deleted",
+	"nuked",
+	"trumped",
+	"dupe",
+	"retitled",
+	"truncated",
+	"season pack",
+	"packs are available",
+	"complete season uploaded",
+	"problem with description",
+	"problem with file",
+	"problem with pack",
+	"specifically banned",
+	"i'm sorry dave, i can't do that", // redacted
+}
+
+// Messages that would match the patterns above but are about the account,
+// a torrent must never be removed because of them.
+var ignoredMessages = []string{
+	"passkey",
+	"authkey",
+	"auth key",
+	"unregistered user",
+	"user not registered",
+	"unregistered device",
+}
+
+type trackerMatcher struct {
+	unregistered []string
+	ignored      []string
+}
+
+func newTrackerMatcher(m model.TrackerMessages) *trackerMatcher {
+	return &trackerMatcher{
+		unregistered: append(slices.Clone(unregisteredMessages), utils.SlicesMap(m.Unregistered, strings.ToLower)...),
+		ignored:      append(slices.Clone(ignoredMessages), utils.SlicesMap(m.Ignored, strings.ToLower)...),
+	}
+}
+
+// Unregistered reports whether the trackers say the torrent no longer exists.
+// A torrent with any working tracker is never unregistered, transmission also
+// keeps the last announce result around after a successful announce.
+func (m *trackerMatcher) Unregistered(t *model.Torrent) bool {
+	var unregistered bool
+	for _, tt := range t.Trackers {
+		if tt.Status == model.TrackerWorking {
+			return false
+		}
+
+		msg := strings.ToLower(tt.Message)
+		if msg == "" || containsAny(msg, m.ignored) {
+			continue
+		}
+		if containsAny(msg, m.unregistered) {
+			unregistered = true
+		}
+	}
+	return unregistered
+}
+
+// trackerError reports whether no tracker is working and at least one failed.
+func trackerError(t *model.Torrent) bool {
+	var failed bool
+	for _, tt := range t.Trackers {
+		switch tt.Status {
+		case model.TrackerWorking:
+			return false
+		case model.TrackerNotWorking, model.TrackerError:
+			failed = true
+		}
+	}
+	return failed
+}
+
+// trackerWorking reports whether any tracker is working.
+func trackerWorking(t *model.Torrent) bool {
+	return slices.ContainsFunc(t.Trackers, func(tt model.TorrentTracker) bool {
+		return tt.Status == model.TrackerWorking
+	})
+}
+
+func containsAny(s string, substrings []string) bool {
+	return slices.ContainsFunc(substrings, func(sub string) bool {
+		return strings.Contains(s, sub)
+	})
+}
@@ -0,0 +1,193 @@
+package exprx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/autobrr/go-qbittorrent"
+	"github.com/hekmon/transmissionrpc/v3"
+
+	"github.com/swkisdust/torrentremover/model"
+)
+
+func qbitTracker(status qbittorrent.TrackerStatus, msg string) model.TorrentTracker {
+	return model.TorrentTracker{URL: "https://tracker.example/announce", Status: model.FromQbitTrackerStatus(status), Message: msg}
+}
+
+func trTracker(stats transmissionrpc.TrackerStats) model.TorrentTracker {
+	return model.TorrentTracker{URL: stats.Announce, Status: model.FromTrTrackerStatus(&stats), Message: stats.LastAnnounceResult}
+}
+
+func delugeTracker(status string) model.TorrentTracker {
+	trackerStatus, msg := model.ParseDelugeTrackerStatus(status)
+	return model.TorrentTracker{URL: "tracker.example", Status: trackerStatus, Message: msg}
+}
+
+func TestTrackerMatcher(t *testing.T) {
+	failedAnnounce := func(msg string) transmissionrpc.TrackerStats {
+		return transmissionrpc.TrackerStats{Announce: "https://tracker.example/announce", AnnounceState: 1, HasAnnounced: true, LastAnnounceResult: msg}
+	}
+
+	tests := []struct {
+		name         string
+		trackers     []model.TorrentTracker
+		unregistered bool
+		err          bool
+	}{
+		{
+			name:         "qBittorrent unregistered torrent",
+			trackers:     []model.TorrentTracker{qbitTracker(qbittorrent.TrackerStatusNotWorking, "Unregistered torrent")},
+			unregistered: true,
+			err:          true,
+		},
+		{
+			name:         "qBittorrent torrent not registered",
+			trackers:     []model.TorrentTracker{qbitTracker(qbittorrent.TrackerStatusNotWorking, "torrent not registered with this tracker")},
+			unregistered: true,
+			err:          true,
+		},
+		{
+			name:         "qBittorrent trumped",
+			trackers:     []model.TorrentTracker{qbitTracker(qbittorrent.TrackerStatusNotWorking, "Trumped: Internal")},
+			unregistered: true,
+			err:          true,
+		},
+		{
+			name:     "qBittorrent unregistered passkey",
+			trackers: []model.TorrentTracker{qbitTracker(qbittorrent.TrackerStatusNotWorking, "Unregistered passkey")},
+			err:      true,
+		},
+		{
+			name:     "qBittorrent timed out",
+			trackers: []model.TorrentTracker{qbitTracker(qbittorrent.TrackerStatusNotWorking, "Connection timed out")},
+			err:      true,
+		},
+		{
+			name:     "qBittorrent working",
+			trackers: []model.TorrentTracker{qbitTracker(qbittorrent.TrackerStatusOK, "")},
+		},
+		{
+			name: "qBittorrent one of two trackers unregistered",
+			trackers: []model.TorrentTracker{
+				qbitTracker(qbittorrent.TrackerStatusNotWorking, "Torrent not found"),
+				qbitTracker(qbittorrent.TrackerStatusOK, ""),
+			},
+		},
+		{
+			name:         "Transmission unregistered torrent",
+			trackers:     []model.TorrentTracker{trTracker(failedAnnounce("Tracker gave an error: Unregistered Torrent"))},
+			unregistered: true,
+			err:          true,
+		},
+		{
+			name:         "Transmission torrent deleted",
+			trackers:     []model.TorrentTracker{trTracker(failedAnnounce("Torrent has been deleted."))},
+			unregistered: true,
+			err:          true,
+		},
+		{
+			name:     "Transmission could not connect",
+			trackers: []model.TorrentTracker{trTracker(failedAnnounce("Could not connect to tracker"))},
+			err:      true,
+		},
+		{
+			name: "Transmission stale result after success",
+			trackers: []model.TorrentTracker{trTracker(transmissionrpc.TrackerStats{
+				AnnounceState: 1, HasAnnounced: true, LastAnnounceSucceeded: true, LastAnnounceResult: "Success",
+			})},
+		},
+		{
+			name:         "Deluge unregistered torrent",
+			trackers:     []model.TorrentTracker{delugeTracker("Error: Unregistered torrent")},
+			unregistered: true,
+			err:          true,
+		},
+		{
+			name:         "Deluge infohash not found",
+			trackers:     []model.TorrentTracker{delugeTracker("Error: infohash not found")},
+			unregistered: true,
+			err:          true,
+		},
+		{
+			name:     "Deluge timed out",
+			trackers: []model.TorrentTracker{delugeTracker("Error: timed out")},
+			err:      true,
+		},
+		{
+			name:     "Deluge announce ok",
+			trackers: []model.TorrentTracker{delugeTracker("Announce OK")},
+		},
+		{
+			name:     "Deluge warning",
+			trackers: []model.TorrentTracker{delugeTracker("Warning: Unregistered torrent")},
+		},
+		{
+			name: "No trackers",
+		},
+	}
+
+	m := newTrackerMatcher(model.TrackerMessages{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			torrent := &model.Torrent{Trackers: tt.trackers}
+			if got := m.Unregistered(torrent); got != tt.unregistered {
+				t.Errorf("tracker_unregistered = %v, want %v", got, tt.unregistered)
+			}
+			if got := trackerError(torrent); got != tt.err {
+				t.Errorf("tracker_error = %v, want %v", got, tt.err)
+			}
+		})
+	}
+}
+
+func TestTrackerMatcherConfig(t *testing.T) {
+	m := newTrackerMatcher(model.TrackerMessages{
+		Unregistered: []string{"Gone Fishing"},
+		Ignored:      []string{"torrent not found in cache"},
+	})
+
+	if !m.Unregistered(&model.Torrent{Trackers: []model.TorrentTracker{qbitTracker(qbittorrent.TrackerStatusNotWorking, "gone fishing")}}) {
+		t.Error("expected custom message to match")
+	}
+	if m.Unregistered(&model.Torrent{Trackers: []model.TorrentTracker{qbitTracker(qbittorrent.TrackerStatusNotWorking, "Torrent not found in cache")}}) {
+		t.Error("expected ignored message not to match")
+	}
+}
+
+func TestTrackerExpr(t *testing.T) {
+	const exprStr = `filter(torrents, tracker_unregistered(#))`
+	torrents := []*model.Torrent{
+		{Hash: "test1", Trackers: []model.TorrentTracker{qbitTracker(qbittorrent.TrackerStatusNotWorking, "Unregistered torrent")}},
+		{Hash: "test2", Trackers: []model.TorrentTracker{qbitTracker(qbittorrent.TrackerStatusOK, "")}},
+	}
+	client := &mockClient{t, torrents[:1]}
+
+	prog, err := Compile(exprStr, client, nil)
+	if err != nil {
+		t.Fatalf("failed to compile expr: %v", err)
+	}
+
+	if err := New(prog, client).Run(context.Background(), torrents, "testSt", RunOptions{}); err != nil {
+		t.Errorf("failed to execute expr: %v", err)
+	}
+}
+
+func TestTrackerStatusExpr(t *testing.T) {
+	const exprStr = `filter(torrents, tracker_working(#) && all(.trackers, .status != tracker_status.not_working))`
+	torrents := []*model.Torrent{
+		{Hash: "test1", Trackers: []model.TorrentTracker{qbitTracker(qbittorrent.TrackerStatusOK, "")}},
+		{Hash: "test2", Trackers: []model.TorrentTracker{delugeTracker("Announce OK")}},
+		{Hash: "test3", Trackers: []model.TorrentTracker{qbitTracker(qbittorrent.TrackerStatusOK, ""), qbitTracker(qbittorrent.TrackerStatusNotWorking, "")}},
+		{Hash: "test4", Trackers: []model.TorrentTracker{delugeTracker("")}},
+	}
+	client := &mockClient{t, torrents[:2]}
+
+	prog, err := Compile(exprStr, client, nil)
+	if err != nil {
+		t.Fatalf("failed to compile expr: %v", err)
+	}
+
+	if err := New(prog, client).Run(context.Background(), torrents, "testSt", RunOptions{}); err != nil {
+		t.Errorf("failed to execute expr: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Entry tracks a torrent that keeps matching a strategy.
+type Entry struct {
+	FirstMatched time.Time `json:"first_matched"`
+	Runs         int       `json:"runs"` // number of consecutive runs the torrent matched
+	Step         int       `json:"step"` // number of completed action steps
+}
+
+// Store persists the pending torrents of every strategy in a JSON file.
+type Store struct {
+	path    string
+	mu      sync.Mutex
+	dirty   bool
+	buckets map[string]map[string]*Entry
+}
+
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:    path,
+		buckets: make(map[string]map[string]*Entry),
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &s.buckets); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Bucket returns the pending torrents of a single strategy.
+func (s *Store) Bucket(name string) *Bucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.buckets[name]; !ok {
+		s.buckets[name] = make(map[string]*Entry)
+	}
+	return &Bucket{s, name}
+}
+
+// Save writes the store back to disk if anything changed since the last save.
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+
+	for name, entries := range s.buckets {
+		if len(entries) == 0 {
+			delete(s.buckets, name)
+		}
+	}
+
+	b, err := json.MarshalIndent(s.buckets, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".state-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	s.dirty = false
+	return nil
+}
+
+type Bucket struct {
+	s    *Store
+	name string
+}
+
+// Track records the currently matching torrents and drops the ones that stopped matching,
+// so an entry only survives as long as its torrent matches on every run.
+// Runs stop counting at maxRuns, the only count a grace period checks, so the
+// store is only rewritten when an entry is added, counted, advanced or dropped.
+// It should be called exactly once per run.
+func (b *Bucket) Track(hashes []string, now time.Time, maxRuns int) map[string]*Entry {
+	b.s.mu.Lock()
+	defer b.s.mu.Unlock()
+
+	entries := b.entries()
+	matched := make(map[string]*Entry, len(hashes))
+	for _, hash := range hashes {
+		e, ok := entries[hash]
+		if !ok {
+			e = &Entry{FirstMatched: now}
+			b.s.dirty = true
+		}
+		if e.Runs < max(maxRuns, 1) {
+			e.Runs++
+			b.s.dirty = true
+		}
+		matched[hash] = e
+	}
+
+	for hash := range entries {
+		if _, ok := matched[hash]; !ok {
+			b.s.dirty = true // stopped matching
+		}
+	}
+	b.s.buckets[b.name] = matched
+
+	return matched
+}
+
+// Advance marks the given step of a torrent as completed.
+func (b *Bucket) Advance(hash string, step int) {
+	b.s.mu.Lock()
+	defer b.s.mu.Unlock()
+
+	if e, ok := b.entries()[hash]; ok {
+		e.Step = step + 1
+		b.s.dirty = true
+	}
+}
+
+// Forget drops a torrent, usually after its last step ran.
+func (b *Bucket) Forget(hash string) {
+	b.s.mu.Lock()
+	defer b.s.mu.Unlock()
+
+	entries := b.entries()
+	if _, ok := entries[hash]; ok {
+		delete(entries, hash)
+		b.s.dirty = true
+	}
+}
+
+func (b *Bucket) entries() map[string]*Entry {
+	entries, ok := b.s.buckets[b.name]
+	if !ok {
+		entries = make(map[string]*Entry)
+		b.s.buckets[b.name] = entries
+	}
+	return entries
+}
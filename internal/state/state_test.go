@@ -0,0 +1,41 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreDirty(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := s.Bucket("test/st")
+	now := time.Now()
+
+	steps := []struct {
+		name  string
+		run   func()
+		dirty bool
+	}{
+		{"nothing tracked", func() { b.Track(nil, now, 0) }, false},
+		{"added", func() { b.Track([]string{"a", "b"}, now, 2) }, true},
+		{"counted", func() { b.Track([]string{"a", "b"}, now, 2) }, true},
+		{"past the grace runs", func() { b.Track([]string{"a", "b"}, now, 2) }, false},
+		{"without grace runs", func() { b.Track([]string{"a", "b"}, now, 0) }, false},
+		{"advanced", func() { b.Advance("a", 0) }, true},
+		{"forgotten", func() { b.Forget("a") }, true},
+		{"forgotten twice", func() { b.Forget("a") }, false},
+		{"stopped matching", func() { b.Track(nil, now, 0) }, true},
+	}
+	for _, step := range steps {
+		step.run()
+		if s.dirty != step.dirty {
+			t.Errorf("%s: dirty = %v, want %v", step.name, s.dirty, step.dirty)
+		}
+		if err := s.Save(); err != nil {
+			t.Fatalf("%s: Save() error: %v", step.name, err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 )
 
@@ -97,3 +98,54 @@ func ParseBytes(s string) (int64, error) {
 
 	return 0, fmt.Errorf("unhandled size name: %v", extra)
 }
+
+// ParseDuration parses a duration string, a bare integer is treated as seconds.
+// On top of time.ParseDuration it accepts days ("d") and weeks ("w"), e.g. "1w3d12h".
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+
+	orig := s
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimLeft(s, "+-")
+	if s == "" {
+		return 0, fmt.Errorf("invalid duration %q", orig)
+	}
+
+	var d time.Duration
+	for s != "" {
+		i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+		if i <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", orig)
+		}
+		j := strings.IndexFunc(s[i:], func(r rune) bool { return unicode.IsDigit(r) || r == '.' })
+		if j < 0 {
+			j = len(s)
+		} else {
+			j += i
+		}
+
+		switch unit := s[i:j]; unit {
+		case "d", "w":
+			n, err := strconv.ParseFloat(s[:i], 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", orig)
+			}
+			d += time.Duration(n * float64(IfOr(unit == "d", 24*time.Hour, 7*24*time.Hour)))
+		default:
+			part, err := time.ParseDuration(s[:j])
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", orig)
+			}
+			d += part
+		}
+		s = s[j:]
+	}
+
+	if neg {
+		return -d, nil
+	}
+	return d, nil
+}
@@ -3,8 +3,18 @@ package model
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/goccy/go-yaml/token"
+
+	"github.com/swkisdust/torrentremover/internal/format"
 )
 
 type Config struct {
@@ -12,6 +22,15 @@ type Config struct {
 	Daemon   DaemonConfig      `json:"daemon"`
 	Clients  map[string]Client `json:"clients,omitempty"`
 	Profiles []Profile         `json:"profiles,omitempty"`
+
+	StateFile       string            `json:"state_file,omitempty"`
+	TrackerMessages TrackerMessages   `json:"tracker_messages,omitempty"`
+	Macros          map[string]string `json:"macros,omitempty"` // named expressions shared by every strategy
+
+	// Include merges other config files into this one, paths and globs are relative to
+	// the including file. Clients and macros must have unique names across files,
+	// profiles are appended and the other settings are taken from the first file setting them.
+	Include format.Array[string] `json:"include,omitempty"`
 }
 
 type Client struct {
@@ -19,6 +38,13 @@ type Client struct {
 	Config map[string]any `json:"config"`
 }
 
+// TrackerMessages extends the built-in tracker message patterns,
+// matched case-insensitively as substrings.
+type TrackerMessages struct {
+	Unregistered []string `json:"unregistered,omitempty"`
+	Ignored      []string `json:"ignored,omitempty"`
+}
+
 type LogConfig struct {
 	Disabled bool   `json:"enabled"`
 	Level    string `json:"level"`
@@ -27,25 +53,327 @@ type LogConfig struct {
 type DaemonConfig struct {
 	Disabled bool   `json:"disabled"`
 	CronExp  string `json:"cron_exp"`
+
+	// WaitClients waits up to this long at startup for the clients to respond,
+	// e.g. when they start together with torrentremover
+	WaitClients Duration `json:"wait_clients,omitempty"`
 }
 
-func (c *Config) Read(f string) error {
-	b, err := os.ReadFile(f)
+// Read reads a config file, or every .yaml and .yml file of a directory in name order,
+// along with the files they include.
+func (c *Config) Read(path string) error {
+	info, err := os.Stat(path)
 	if err != nil {
 		return err
 	}
 
-	if err := yaml.Unmarshal(b, c); err != nil {
+	l := &configLoader{loaded: make(map[string]bool), defined: make(map[string]string)}
+	if info.IsDir() {
+		files, err := configFiles(path)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no config files found in %s", path)
+		}
+		for _, f := range files {
+			if err := l.load(c, f); err != nil {
+				return err
+			}
+		}
+	} else if err := l.load(c, path); err != nil {
 		return err
 	}
 
+	// strategy names key the state of each client's strategies, see state.Store
+	names := make(map[string]map[string]bool)
 	for i, profile := range c.Profiles {
+		switch profile.FreeSpaceSource {
+		case "", "client", "local", "auto":
+		default:
+			return fmt.Errorf("profiles[%d].free_space_source: unknown source %q", i, profile.FreeSpaceSource)
+		}
 		for j, st := range profile.Strategy {
 			if st.Name == "" {
 				return fmt.Errorf("profiles[%d].strategy[%d] needs a name", i, j)
 			}
+			if names[profile.Client] == nil {
+				names[profile.Client] = make(map[string]bool)
+			}
+			if names[profile.Client][st.Name] {
+				return fmt.Errorf("profiles[%d].strategy[%d]: client %q already has a strategy named %q", i, j, profile.Client, st.Name)
+			}
+			names[profile.Client][st.Name] = true
+			if st.RemoveExpr == "" && st.Match == "" && st.ScoreExpr == "" && st.Script == "" && st.Filter.IsZero() {
+				return fmt.Errorf("profiles[%d].strategy[%d] needs filters besides disk or an expression, use expr: torrents to select every torrent", i, j)
+			}
+			if st.PerDisk && profile.FreeSpaceSource != "local" && profile.FreeSpaceSource != "auto" {
+				return fmt.Errorf("profiles[%d].strategy[%d].per_disk needs free_space_source local or auto", i, j)
+			}
+			switch st.CrossSeed {
+			case "", CrossSeedTogether, CrossSeedKeepFiles:
+			default:
+				return fmt.Errorf("profiles[%d].strategy[%d].cross_seed: unknown mode %q", i, j, st.CrossSeed)
+			}
+			if err := c.Profiles[i].Strategy[j].Filter.Compile(); err != nil {
+				return fmt.Errorf("profiles[%d].strategy[%d].filters: %w", i, j, err)
+			}
+			if st.Action != "" && len(st.Actions) > 0 {
+				return fmt.Errorf("profiles[%d].strategy[%d]: set either action or actions", i, j)
+			}
+			if st.Action != "" && !slices.Contains(Actions, st.Action) {
+				return fmt.Errorf("profiles[%d].strategy[%d].action: unknown action %q", i, j, st.Action)
+			}
+			if st.Action == "tag" && len(st.AddTags) == 0 {
+				return fmt.Errorf("profiles[%d].strategy[%d]: the tag action needs add_tags", i, j)
+			}
+			for k, step := range st.Actions {
+				if step.Action == "" {
+					return fmt.Errorf("profiles[%d].strategy[%d].actions[%d] needs an action", i, j, k)
+				}
+				if !slices.Contains(Actions, step.Action) {
+					return fmt.Errorf("profiles[%d].strategy[%d].actions[%d]: unknown action %q", i, j, k, step.Action)
+				}
+				if step.Action == "tag" && len(step.AddTags) == 0 {
+					return fmt.Errorf("profiles[%d].strategy[%d].actions[%d]: the tag action needs add_tags", i, j, k)
+				}
+			}
+			// in scoring mode limit caps the number of torrents, unless it's needed as the upload limit
+			if st.ScoreExpr != "" && st.Limit != 0 && !st.throttles() {
+				if st.ScoreLimit != 0 {
+					return fmt.Errorf("profiles[%d].strategy[%d]: set either limit or score_limit", i, j)
+				}
+				if st.Limit < 0 {
+					return fmt.Errorf("profiles[%d].strategy[%d].limit can't be negative", i, j)
+				}
+				c.Profiles[i].Strategy[j].ScoreLimit, c.Profiles[i].Strategy[j].Limit = int(st.Limit), 0
+			}
+		}
+	}
+
+	return nil
+}
+
+// configLoader merges config files into one Config.
+type configLoader struct {
+	loaded  map[string]bool   // absolute paths, a file matched by several includes is loaded once
+	defined map[string]string // file defining every client and macro, for duplicate errors
+}
+
+func (l *configLoader) load(c *Config, path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if l.loaded[abs] {
+		return nil
+	}
+	l.loaded[abs] = true
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	file, err := parser.ParseBytes(b, 0)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	var f Config
+	if len(file.Docs) > 0 && file.Docs[0].Body != nil {
+		body := file.Docs[0].Body
+		if err := expandEnv(body); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := yaml.NodeToValue(body, &f); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := l.merge(c, &f, path); err != nil {
+		return err
+	}
+
+	for _, pattern := range f.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: include %q: %w", path, pattern, err)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return fmt.Errorf("%s: include %q: %w", path, pattern, os.ErrNotExist)
+		}
+		for _, m := range matches {
+			if err := l.load(c, m); err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
+
+func (l *configLoader) merge(c *Config, f *Config, path string) error {
+	for name, client := range f.Clients {
+		if prev, ok := l.defined["client "+name]; ok {
+			return fmt.Errorf("%s: client %q is already defined in %s", path, name, prev)
+		}
+		l.defined["client "+name] = path
+		if c.Clients == nil {
+			c.Clients = make(map[string]Client)
+		}
+		c.Clients[name] = client
+	}
+
+	for name, body := range f.Macros {
+		if prev, ok := l.defined["macro "+name]; ok {
+			return fmt.Errorf("%s: macro %q is already defined in %s", path, name, prev)
+		}
+		l.defined["macro "+name] = path
+		if c.Macros == nil {
+			c.Macros = make(map[string]string)
+		}
+		c.Macros[name] = body
+	}
+
+	c.Profiles = append(c.Profiles, f.Profiles...)
+	c.TrackerMessages.Unregistered = append(c.TrackerMessages.Unregistered, f.TrackerMessages.Unregistered...)
+	c.TrackerMessages.Ignored = append(c.TrackerMessages.Ignored, f.TrackerMessages.Ignored...)
+
+	if c.Log == (LogConfig{}) {
+		c.Log = f.Log
+	}
+	if c.Daemon == (DaemonConfig{}) {
+		c.Daemon = f.Daemon
+	}
+	if c.StateFile == "" {
+		c.StateFile = f.StateFile
+	}
+	return nil
+}
+
+// configFiles returns the .yaml and .yml files of a directory, sorted by name.
+func configFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, e := range entries {
+		if ext := filepath.Ext(e.Name()); !e.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	return files, nil
+}
+
+var envRe = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-[^}]*)?\}`)
+
+// envExpander replaces ${VAR} with the environment variable and ${VAR:-default} with
+// a default if it's unset or empty, $${VAR} is left as ${VAR}. Unlike os.ExpandEnv
+// a bare $VAR is kept since expressions and regexes use $.
+// Only string values of the parsed config are expanded, so a variable containing
+// # or : can't change the YAML structure and commented out lines are ignored.
+// An unquoted value becomes a bool or a number if that's what it expands to,
+// e.g. disabled: ${DISABLED:-false}, a quoted one always stays a string.
+type envExpander struct {
+	skip map[ast.Node]bool // mapping keys and the values already expanded
+	err  error
+}
+
+func expandEnv(node ast.Node) error {
+	e := &envExpander{skip: make(map[ast.Node]bool)}
+	ast.Walk(e, node)
+	return e.err
+}
+
+func (e *envExpander) Visit(node ast.Node) ast.Visitor {
+	switch n := node.(type) {
+	case *ast.MappingValueNode:
+		e.skip[n.Key] = true
+		n.Value = e.expandScalar(n.Value)
+	case *ast.SequenceNode:
+		for i, value := range n.Values {
+			n.Values[i] = e.expandScalar(value)
+		}
+	case *ast.StringNode:
+		// strings that aren't a mapping or sequence value, e.g. behind an anchor
+		e.expandString(n)
+	}
+	return e
+}
+
+// expandScalar expands a string value, replacing an unquoted one by a bool,
+// number or null node when it expands to one.
+func (e *envExpander) expandScalar(node ast.Node) ast.Node {
+	n, ok := node.(*ast.StringNode)
+	if !ok || !e.expandString(n) || n.Token.Type != token.StringType {
+		return node
+	}
+
+	tk := token.New(n.Value, n.Token.Origin, n.Token.Position)
+	switch tk.Type {
+	case token.BoolType:
+		return ast.Bool(tk)
+	case token.IntegerType, token.BinaryIntegerType, token.OctetIntegerType, token.HexIntegerType:
+		return ast.Integer(tk)
+	case token.FloatType:
+		return ast.Float(tk)
+	case token.NullType:
+		return ast.Null(tk)
+	}
+	return n
+}
+
+// expandString expands n in place and reports whether it changed.
+func (e *envExpander) expandString(n *ast.StringNode) bool {
+	if e.skip[n] || !strings.Contains(n.Value, "${") {
+		return false
+	}
+	e.skip[n] = true
+
+	expanded := e.expand(n.Value)
+	if expanded == n.Value {
+		return false
+	}
+	n.Value = expanded
+	n.Token.Value = expanded
+
+	// types with UnmarshalYAML get the source of the token, so it's replaced by the
+	// expanded value, keeping the surrounding whitespace. A string is quoted if it
+	// was, or if it has to be so its source isn't read as something else
+	core := expanded
+	typed := token.New(expanded, expanded, n.Token.Position).Type != token.StringType
+	if n.Token.Type != token.StringType || !typed && token.IsNeedQuoted(expanded) {
+		n.Token.Type = token.DoubleQuoteType
+		core = strconv.Quote(expanded)
+	}
+	origin := n.Token.Origin
+	trimmed := strings.TrimSpace(origin)
+	start := strings.Index(origin, trimmed)
+	n.Token.Origin = origin[:start] + core + origin[start+len(trimmed):]
+	return true
+}
+
+func (e *envExpander) expand(s string) string {
+	return envRe.ReplaceAllStringFunc(s, func(m string) string {
+		if strings.HasPrefix(m, "$$") {
+			return m[1:]
+		}
+
+		match := envRe.FindStringSubmatch(m)
+		name := match[1]
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+		if def, ok := strings.CutPrefix(match[2], ":-"); ok {
+			return def
+		}
+		if _, ok := os.LookupEnv(name); !ok && e.err == nil {
+			e.err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return ""
+	})
+}
@@ -0,0 +1,343 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigReadEnv(t *testing.T) {
+	t.Setenv("TR_TEST_PASSWORD", "secret")
+	t.Setenv("TR_TEST_EMPTY", "")
+	t.Setenv("TR_TEST_SPECIAL", "abc #1: \"x\"\nnext: y")
+	t.Setenv("TR_TEST_SCORE_LIMIT", "5")
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	raw := `
+daemon:
+  disabled: ${TR_TEST_UNSET:-true}
+  wait_clients: ${TR_TEST_EMPTY:-90s}
+clients:
+  qb:
+    type: qbittorrent
+    config:
+      password: ${TR_TEST_PASSWORD}
+      username: ${TR_TEST_EMPTY:-admin}
+      host: $${TR_TEST_PASSWORD}
+      basic_pass: ${TR_TEST_SPECIAL}
+      basic_user: "${TR_TEST_UNSET:-true}"
+      # basic_user: ${TR_TEST_UNSET}
+profiles:
+  - client: qb
+    strategy:
+      - name: test
+        expr: filter(torrents, .name matches "x$")
+        score_expr: ratio
+        score_limit: ${TR_TEST_SCORE_LIMIT}
+`
+	if err := os.WriteFile(path, []byte(raw), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var c Config
+	if err := c.Read(path); err != nil {
+		t.Fatalf("Read() error: %v", err)
+	}
+
+	if c.Daemon.WaitClients != Duration(90*time.Second) {
+		t.Errorf("wait_clients = %v, want 90s", c.Daemon.WaitClients)
+	}
+	if !c.Daemon.Disabled {
+		t.Error("expected a variable to set a bool field")
+	}
+	if limit := c.Profiles[0].Strategy[0].ScoreLimit; limit != 5 {
+		t.Errorf("expected a variable to set an int field, got %d", limit)
+	}
+
+	config := c.Clients["qb"].Config
+	for key, expected := range map[string]string{
+		"password":   "secret",
+		"username":   "admin",
+		"host":       "${TR_TEST_PASSWORD}",
+		"basic_pass": "abc #1: \"x\"\nnext: y",
+		"basic_user": "true",
+	} {
+		if config[key] != expected {
+			t.Errorf("%s = %v, want %q", key, config[key], expected)
+		}
+	}
+	if _, ok := config["next"]; ok || len(config) != 5 {
+		t.Errorf("expected a variable not to change the config structure, got %v", config)
+	}
+	if expr := c.Profiles[0].Strategy[0].RemoveExpr; expr != `filter(torrents, .name matches "x$")` {
+		t.Errorf("expected a bare $ to be kept, got %q", expr)
+	}
+
+	if err := os.WriteFile(path, []byte("state_file: ${TR_TEST_UNSET}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Read(path); err == nil {
+		t.Error("expected an unset variable without default to fail")
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConfigReadInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config.yaml": `
+state_file: /data/state.json
+include:
+  - clients.yaml
+  - profiles/*.yaml
+`,
+		"clients.yaml": `
+state_file: /ignored.json
+clients:
+  qb: {type: qbittorrent}
+  tr: {type: transmission}
+macros:
+  seeded: .ratio > 2
+`,
+		"profiles/qb.yaml": `
+profiles:
+  - client: qb
+    strategy:
+      - name: seeded
+        expr: filter(torrents, seeded)
+`,
+		"profiles/tr.yaml": `
+profiles:
+  - client: tr
+    strategy:
+      - name: all
+        expr: torrents
+`,
+	})
+
+	var c Config
+	if err := c.Read(filepath.Join(dir, "config.yaml")); err != nil {
+		t.Fatalf("Read() error: %v", err)
+	}
+	if len(c.Clients) != 2 || len(c.Profiles) != 2 || len(c.Macros) != 1 {
+		t.Errorf("expected 2 clients, 2 profiles and 1 macro, got %d, %d and %d", len(c.Clients), len(c.Profiles), len(c.Macros))
+	}
+	if c.StateFile != "/data/state.json" {
+		t.Errorf("expected the including file's state_file, got %q", c.StateFile)
+	}
+
+	writeFiles(t, dir, map[string]string{"config.yaml": "include: missing.yaml\n"})
+	if err := new(Config).Read(filepath.Join(dir, "config.yaml")); err == nil {
+		t.Error("expected a missing include to fail")
+	}
+}
+
+func TestConfigReadDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"10-clients.yaml": "clients:\n  qb: {type: qbittorrent}\n",
+		"20-profiles.yml": "profiles:\n  - client: qb\n    strategy:\n      - name: all\n        expr: torrents\n",
+		"README.md":       "not a config",
+	})
+
+	var c Config
+	if err := c.Read(dir); err != nil {
+		t.Fatalf("Read() error: %v", err)
+	}
+	if len(c.Clients) != 1 || len(c.Profiles) != 1 {
+		t.Errorf("expected 1 client and 1 profile, got %d and %d", len(c.Clients), len(c.Profiles))
+	}
+
+	writeFiles(t, dir, map[string]string{"30-more.yaml": "clients:\n  qb: {type: deluge}\n"})
+	err := new(Config).Read(dir)
+	if err == nil || !strings.Contains(err.Error(), `client "qb" is already defined in `+filepath.Join(dir, "10-clients.yaml")) {
+		t.Errorf("expected a duplicate client error, got %v", err)
+	}
+}
+
+func TestConfigReadActions(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		action   string
+		expected string // error substring, empty when valid
+	}{
+		{"strategy action", "action: puase", `unknown action "puase"`},
+		{"step action", "actions:\n          - action: puase", `unknown action "puase"`},
+		{"action and actions", "action: pause\n        actions:\n          - action: remove", "set either action or actions"},
+		{"tag without add_tags", "action: tag", "tag action needs add_tags"},
+		{"tag step without add_tags", "add_tags: x\n        actions:\n          - action: tag", "tag action needs add_tags"},
+		{"tag", "action: tag\n        add_tags: x", ""},
+		{"tag step", "actions:\n          - action: tag\n            add_tags: x", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeFiles(t, dir, map[string]string{"config.yaml": `
+profiles:
+  - client: qb
+    strategy:
+      - name: test
+        expr: torrents
+        ` + tt.action + "\n"})
+
+			err := new(Config).Read(filepath.Join(dir, "config.yaml"))
+			if tt.expected == "" && err != nil {
+				t.Errorf("Read() error: %v", err)
+			}
+			if tt.expected != "" && (err == nil || !strings.Contains(err.Error(), tt.expected)) {
+				t.Errorf("expected an error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestConfigReadScoreLimit(t *testing.T) {
+	dir := t.TempDir()
+	read := func(strategy string) (Strategy, error) {
+		writeFiles(t, dir, map[string]string{"config.yaml": `
+profiles:
+  - client: qb
+    strategy:
+      - name: test
+        score_expr: ratio
+` + strategy})
+
+		var c Config
+		if err := c.Read(filepath.Join(dir, "config.yaml")); err != nil {
+			return Strategy{}, err
+		}
+		return c.Profiles[0].Strategy[0], nil
+	}
+
+	st, err := read("        limit: 5\n")
+	if err != nil {
+		t.Fatalf("Read() error: %v", err)
+	}
+	if st.ScoreLimit != 5 || st.Limit != 0 {
+		t.Errorf("expected limit to cap the scored torrents, got score_limit %d and limit %d", st.ScoreLimit, st.Limit)
+	}
+
+	st, err = read("        action: throttle\n        limit: 1MiB\n        score_limit: 5\n")
+	if err != nil {
+		t.Fatalf("Read() error: %v", err)
+	}
+	if st.ScoreLimit != 5 || st.Limit != 1<<20 {
+		t.Errorf("expected limit to stay the upload limit of a throttle action, got score_limit %d and limit %d", st.ScoreLimit, st.Limit)
+	}
+
+	if _, err := read("        limit: 5\n        score_limit: 3\n"); err == nil {
+		t.Error("expected an error when both limit and score_limit are set")
+	}
+}
+
+func TestConfigReadNegativeLimits(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"config.yaml": `
+profiles:
+  - client: qb
+    strategy:
+      - name: test
+        action: throttle
+        expr: torrents
+        limit: -1
+        download_limit: -1MiB
+`})
+
+	var c Config
+	if err := c.Read(filepath.Join(dir, "config.yaml")); err != nil {
+		t.Fatalf("Read() error: %v", err)
+	}
+	if st := c.Profiles[0].Strategy[0]; st.Limit != -1 || st.DownloadLimit != -1<<20 {
+		t.Errorf("expected negative limits, got limit %d and download_limit %d", st.Limit, st.DownloadLimit)
+	}
+}
+
+func TestConfigReadDiskOnly(t *testing.T) {
+	dir := t.TempDir()
+	for strategy, ok := range map[string]bool{
+		"filters: {disk: 100GiB}\n":                         false,
+		"filters: {disk: 100GiB, all_of: [{disk: 1}]}\n":    false,
+		"filters: {disk: 100GiB}\n        expr: torrents\n": true,
+		"filters: {disk: 100GiB, categories: movies}\n":     true,
+	} {
+		writeFiles(t, dir, map[string]string{"config.yaml": `
+profiles:
+  - client: qb
+    strategy:
+      - name: test
+        ` + strategy})
+
+		err := new(Config).Read(filepath.Join(dir, "config.yaml"))
+		if ok && err != nil {
+			t.Errorf("%q: Read() error: %v", strategy, err)
+		}
+		if !ok && (err == nil || !strings.Contains(err.Error(), "needs filters besides disk")) {
+			t.Errorf("%q: expected a disk-only strategy to be rejected, got %v", strategy, err)
+		}
+	}
+}
+
+func TestConfigReadDuplicateStrategy(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"10-qb.yaml": "profiles:\n  - client: qb\n    strategy:\n      - name: all\n        expr: torrents\n",
+		"20-qb.yaml": "profiles:\n  - client: qb\n    strategy:\n      - name: all\n        expr: torrents\n",
+		"30-tr.yaml": "profiles:\n  - client: tr\n    strategy:\n      - name: all\n        expr: torrents\n",
+	})
+
+	err := new(Config).Read(dir)
+	if err == nil || !strings.Contains(err.Error(), `client "qb" already has a strategy named "all"`) {
+		t.Errorf("expected a duplicate strategy error, got %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "20-qb.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := new(Config).Read(dir); err != nil {
+		t.Errorf("expected the same name on another client to be accepted, got %v", err)
+	}
+}
+
+func TestConfigReadNoTags(t *testing.T) {
+	dir := t.TempDir()
+	read := func(filters string) (Filters, error) {
+		writeFiles(t, dir, map[string]string{"config.yaml": `
+profiles:
+  - client: qb
+    strategy:
+      - name: test
+        filters: ` + filters + "\n"})
+
+		var c Config
+		if err := c.Read(filepath.Join(dir, "config.yaml")); err != nil {
+			return Filters{}, err
+		}
+		return c.Profiles[0].Strategy[0].Filter, nil
+	}
+
+	f, err := read("{categories: x, no_tags: true}")
+	if err != nil {
+		t.Fatalf("Read() error: %v", err)
+	}
+	if f.Untagged == nil || !*f.Untagged {
+		t.Error("expected no_tags to be read as untagged: true")
+	}
+
+	if _, err := read("{no_tags: true, untagged: false}"); err == nil {
+		t.Error("expected no_tags with untagged: false to be rejected")
+	}
+}
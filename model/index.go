@@ -0,0 +1,89 @@
+package model
+
+import (
+	"path"
+	"slices"
+	"sync"
+)
+
+// Cross-seed modes of a strategy.
+const (
+	CrossSeedTogether  = "together"   // remove the torrent from every client seeding the same data
+	CrossSeedKeepFiles = "keep_files" // keep the files while another torrent still references them
+)
+
+// TorrentRef is a torrent of a named client.
+type TorrentRef struct {
+	Client  string
+	Torrent *Torrent
+}
+
+// TorrentIndex finds the torrents of every client seeding the same data,
+// either the same infohash or the same content path.
+type TorrentIndex struct {
+	mu     sync.Mutex
+	byHash map[string][]TorrentRef
+	byPath map[string][]TorrentRef
+}
+
+// NewIndex indexes the torrents of every client by client name.
+func NewIndex(torrents map[string][]*Torrent) *TorrentIndex {
+	idx := &TorrentIndex{
+		byHash: make(map[string][]TorrentRef),
+		byPath: make(map[string][]TorrentRef),
+	}
+	for client, ts := range torrents {
+		for _, t := range ts {
+			ref := TorrentRef{client, t}
+			idx.byHash[t.Hash] = append(idx.byHash[t.Hash], ref)
+			if p := indexPath(t); p != "" {
+				idx.byPath[p] = append(idx.byPath[p], ref)
+			}
+		}
+	}
+	return idx
+}
+
+// Others returns the other torrents sharing the hash or content path of a client's torrent.
+func (idx *TorrentIndex) Others(client string, t *Torrent) []TorrentRef {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	var others []TorrentRef
+	add := func(refs []TorrentRef) {
+		for _, ref := range refs {
+			if ref.Client == client && ref.Torrent.Hash == t.Hash {
+				continue
+			}
+			if !slices.ContainsFunc(others, func(o TorrentRef) bool {
+				return o.Client == ref.Client && o.Torrent.Hash == ref.Torrent.Hash
+			}) {
+				others = append(others, ref)
+			}
+		}
+	}
+	add(idx.byHash[t.Hash])
+	if p := indexPath(t); p != "" {
+		add(idx.byPath[p])
+	}
+	return others
+}
+
+// Remove drops a client's torrent after it was removed, so later strategies don't see it.
+func (idx *TorrentIndex) Remove(client string, t *Torrent) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	match := func(ref TorrentRef) bool { return ref.Client == client && ref.Torrent.Hash == t.Hash }
+	idx.byHash[t.Hash] = slices.DeleteFunc(idx.byHash[t.Hash], match)
+	if p := indexPath(t); p != "" {
+		idx.byPath[p] = slices.DeleteFunc(idx.byPath[p], match)
+	}
+}
+
+func indexPath(t *Torrent) string {
+	if t.ContentPath == "" {
+		return ""
+	}
+	return path.Clean(t.ContentPath)
+}
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTorrentIndex(t *testing.T) {
+	public := []*Torrent{
+		{Hash: "a", ContentPath: "/data/movie"},
+		{Hash: "b", ContentPath: "/data/show/"},
+		{Hash: "c"},
+	}
+	private := []*Torrent{
+		{Hash: "a", ContentPath: "/data/movie"},
+		{Hash: "d", ContentPath: "/data/show"},
+		{Hash: "e"},
+	}
+	idx := NewIndex(map[string][]*Torrent{"public": public, "private": private})
+
+	tests := []struct {
+		name     string
+		torrent  *Torrent
+		expected []TorrentRef
+	}{
+		{"same hash", public[0], []TorrentRef{{"private", private[0]}}},
+		{"same content path", public[1], []TorrentRef{{"private", private[1]}}},
+		{"no content path", public[2], nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := idx.Others("public", tt.torrent); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Others() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+
+	idx.Remove("private", private[1])
+	if got := idx.Others("public", public[1]); len(got) != 0 {
+		t.Errorf("expected no cross-seeds after Remove(), got %v", got)
+	}
+}
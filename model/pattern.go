@@ -0,0 +1,117 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Pattern matches a filter value, "re:" starts a regular expression and "glob:" a glob,
+// anything else is matched literally (exactly or as a substring), so values like
+// announce.php?passkey or [::1] need no escaping.
+type Pattern struct {
+	literal string
+	re      *regexp.Regexp
+}
+
+func CompilePattern(s string) (Pattern, error) {
+	if expr, ok := strings.CutPrefix(s, "re:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return Pattern{}, fmt.Errorf("invalid pattern %q: %w", s, err)
+		}
+		return Pattern{re: re}, nil
+	}
+
+	if glob, ok := strings.CutPrefix(s, "glob:"); ok {
+		re, err := regexp.Compile(globToRegexp(glob))
+		if err != nil {
+			return Pattern{}, fmt.Errorf("invalid glob %q: %w", s, err)
+		}
+		return Pattern{re: re}, nil
+	}
+
+	return Pattern{literal: s}, nil
+}
+
+// compileRegexp compiles a pattern that is always a regular expression, the "re:" prefix is optional.
+func compileRegexp(s string) (Pattern, error) {
+	re, err := regexp.Compile(strings.TrimPrefix(s, "re:"))
+	if err != nil {
+		return Pattern{}, fmt.Errorf("invalid pattern %q: %w", s, err)
+	}
+	return Pattern{re: re}, nil
+}
+
+// Match reports whether s matches, literal patterns match exactly.
+func (p Pattern) Match(s string) bool {
+	if p.re != nil {
+		return p.re.MatchString(s)
+	}
+	return p.literal == s
+}
+
+// Contains is like Match but literal patterns match as a substring.
+func (p Pattern) Contains(s string) bool {
+	if p.re != nil {
+		return p.re.MatchString(s)
+	}
+	return strings.Contains(s, p.literal)
+}
+
+// globToRegexp converts a glob to an anchored regular expression,
+// unlike path.Match * also matches "/" since categories may contain it.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+type filterPatterns struct {
+	names, categories, tags, allTags, trackers                        []Pattern
+	excludedNames, excludedCategories, excludedTags, excludedTrackers []Pattern
+}
+
+func compilePatterns(values []string, compile func(string) (Pattern, error)) ([]Pattern, error) {
+	patterns := make([]Pattern, 0, len(values))
+	for _, v := range values {
+		p, err := compile(v)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+func matchAny(patterns []Pattern, s string, match func(Pattern, string) bool) bool {
+	for _, p := range patterns {
+		if match(p, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package model
+
+import "testing"
+
+func TestCompilePattern(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		value    string
+		match    bool
+		contains bool
+	}{
+		{"announce.php?passkey", "https://tracker.example/announce.php?passkey=abc", false, true},
+		{"[::1]", "http://[::1]:6969/announce", false, true},
+		{"[::1]", "http://1:6969/announce", false, false},
+		{"TV*", "TV Shows", false, false},
+		{"glob:TV*", "TV Shows", true, true},
+		{"glob:M?sic", "Music", true, true},
+		{"glob:[hs]d", "sd", true, true},
+		{"glob:[!hs]d", "sd", false, false},
+		{"glob:a.com*", "tracker.a.com", false, false},
+		{`re:^tracker\.[cd]\.com$`, "tracker.c.com", true, true},
+	}
+	for _, tt := range tests {
+		p, err := CompilePattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("CompilePattern(%q) error: %v", tt.pattern, err)
+		}
+		if got := p.Match(tt.value); got != tt.match {
+			t.Errorf("%q.Match(%q) = %v, want %v", tt.pattern, tt.value, got, tt.match)
+		}
+		if got := p.Contains(tt.value); got != tt.contains {
+			t.Errorf("%q.Contains(%q) = %v, want %v", tt.pattern, tt.value, got, tt.contains)
+		}
+	}
+
+	if _, err := CompilePattern("re:("); err == nil {
+		t.Error("expected an invalid regexp to fail")
+	}
+}
@@ -7,4 +7,10 @@ type Profile struct {
 	DeleteFiles bool       `json:"delete_files,omitempty"`
 	DeleteDelay uint32     `json:"delete_delay,omitempty"`
 	Mountpath   string     `json:"mount_path,omitempty"`
+
+	// Where free space comes from, "client" (default) asks the client, "local" checks the disks
+	// on this host, with the disk filter applied per torrent to the disk it's saved on,
+	// and "auto" checks locally when the path exists on this host.
+	FreeSpaceSource string            `json:"free_space_source,omitempty"`
+	PathMap         map[string]string `json:"path_map,omitempty"` // client path prefix to local path, e.g. "/downloads": "/mnt/disk1"
 }
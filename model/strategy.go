@@ -1,8 +1,12 @@
 package model
 
 import (
+	"cmp"
 	"fmt"
+	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"slices"
 
@@ -15,31 +19,249 @@ import (
 )
 
 type Strategy struct {
-	Name        string      `json:"name"`
-	Filter      Filters     `json:"filters"`
-	Action      string      `json:"action,omitempty"`
-	Limit       Bytes       `json:"limit,omitempty"`
-	Reannounce  bool        `json:"reannounce,omitempty"`
-	DeleteFiles bool        `json:"delete_files,omitempty"`
-	DeleteDelay uint32      `json:"delete_delay,omitempty"`
-	Duration    uint32      `json:"duration,omitempty"`
-	Mountpath   string      `json:"mount_path,omitempty"`
-	RemoveExpr  string      `json:"expr,omitempty"`
-	Prog        *vm.Program `json:"-"`
+	Name        string               `json:"name"`
+	Filter      Filters              `json:"filters"`
+	Action      string               `json:"action,omitempty"`
+	Actions     []Step               `json:"actions,omitempty"`
+	AddTags     format.Array[string] `json:"add_tags,omitempty"`
+	Reannounce  bool                 `json:"reannounce,omitempty"`
+	DeleteFiles bool                 `json:"delete_files,omitempty"`
+	DeleteDelay uint32               `json:"delete_delay,omitempty"`
+	Duration    uint32               `json:"duration,omitempty"`
+	Mountpath   string               `json:"mount_path,omitempty"`
+	RemoveExpr  string               `json:"expr,omitempty"`  // optional, defaults to every torrent left by the filters
+	Match       string               `json:"match,omitempty"` // per-torrent predicate, evaluated before expr
+	GracePeriod GracePeriod          `json:"grace_period,omitempty"`
+	Prog        *vm.Program          `json:"-"`
+	MatchProg   *vm.Program          `json:"-"`
+
+	// Scoring mode, the lowest scoring torrents are acted on first until
+	// the disk target and ScoreLimit (if set) are reached. Without a throttle
+	// action limit is accepted for ScoreLimit, see Config.Read.
+	ScoreExpr  string      `json:"score_expr,omitempty"`
+	ScoreLimit int         `json:"score_limit,omitempty"`
+	ScoreProg  *vm.Program `json:"-"`
+
+	// Starlark script defining select(torrents, env), see exprx.Script
+	Script         string   `json:"script,omitempty"`
+	ScriptTimeout  Duration `json:"script_timeout,omitempty"`
+	ScriptMaxSteps uint64   `json:"script_max_steps,omitempty"`
+
+	// Run once per mount the filtered torrents are saved on, with that disk's
+	// free space as disk, needs free_space_source local or auto
+	PerDisk bool `json:"per_disk,omitempty"`
+
+	// How torrents seeding the same data from another client (or under another hash)
+	// are handled on removal, CrossSeedTogether or CrossSeedKeepFiles
+	CrossSeed string `json:"cross_seed,omitempty"`
+
+	// Throttle limits, zero leaves the limit untouched and -1 removes it
+	Limit            Bytes    `json:"limit,omitempty"` // upload limit
+	DownloadLimit    Bytes    `json:"download_limit,omitempty"`
+	RatioLimit       float64  `json:"ratio_limit,omitempty"`
+	SeedingTimeLimit Duration `json:"seeding_time_limit,omitempty"`
+	BandwidthGroup   string   `json:"bandwidth_group,omitempty"` // transmission only
 }
 
+// throttles reports whether the strategy's action or any step throttles torrents.
+func (s *Strategy) throttles() bool {
+	return s.Action == "throttle" || slices.ContainsFunc(s.Actions, func(step Step) bool {
+		return step.Action == "throttle"
+	})
+}
+
+func (s *Strategy) Limits() Limits {
+	return Limits{
+		Upload:      s.Limit,
+		Download:    s.DownloadLimit,
+		Ratio:       s.RatioLimit,
+		SeedingTime: time.Duration(s.SeedingTimeLimit),
+		Group:       utils.IfOr(s.BandwidthGroup != "", &s.BandwidthGroup, nil),
+	}
+}
+
+// Limits holds the per-torrent limits applied by the throttle actions.
+// A zero value leaves the corresponding limit untouched, a negative value removes it.
+// Clients without a per-torrent seeding time limit only warn about a positive one,
+// since there is nothing to remove.
+type Limits struct {
+	Upload      Bytes
+	Download    Bytes
+	Ratio       float64
+	SeedingTime time.Duration
+	GlobalShare bool    // put ratio and seeding time back on the client's global limits, overrides both
+	Group       *string // bandwidth group, nil leaves it untouched and empty removes it
+}
+
+// NoLimits clears every per-torrent limit and restores the global share limits, used by the unthrottle action.
+var NoLimits = Limits{Upload: -1, Download: -1, GlobalShare: true, Group: new(string)}
+
+// Actions are the names accepted by action and actions[].action, an empty action removes.
+var Actions = []string{"remove", "pause", "resume", "recheck", "force_start", "reannounce", "tag", "throttle", "unthrottle"}
+
+// Step is a single action of a chained strategy, it runs once the torrent
+// has matched continuously for Delay.
+type Step struct {
+	Action  string               `json:"action"`
+	Delay   Duration             `json:"delay,omitempty"`
+	AddTags format.Array[string] `json:"add_tags,omitempty"`
+}
+
+// Filters select the torrents passed to a strategy's expressions.
+// Categories, tags and trackers accept the Pattern syntax, names are regular expressions.
 type Filters struct {
+	Names      format.Array[string] `json:"names,omitempty"`
 	Categories format.Array[string] `json:"categories,omitempty"`
 	Tags       format.Array[string] `json:"tags,omitempty"`
 	Trackers   format.Array[string] `json:"trackers,omitempty"`
 	Status     format.Array[Status] `json:"status,omitempty"`
+	AllTags    format.Array[string] `json:"all_tags,omitempty"` // every pattern has to match a tag
+
+	// Untagged keeps only torrents without tags when true and only tagged ones when false,
+	// no_tags: true is an alias of untagged: true.
+	Untagged *bool `json:"untagged,omitempty"`
+	NoTags   bool  `json:"no_tags,omitempty"`
+	MinTags  *int  `json:"min_tags,omitempty"`
+	MaxTags  *int  `json:"max_tags,omitempty"`
 
+	ExcludedNames      format.Array[string] `json:"excluded_names,omitempty"`
 	ExcludedCategories format.Array[string] `json:"excluded_categories,omitempty"`
 	ExcludedTags       format.Array[string] `json:"excluded_tags,omitempty"`
 	ExcludedTrackers   format.Array[string] `json:"excluded_trackers,omitempty"`
 	ExcludedStatus     format.Array[Status] `json:"excluded_status,omitempty"`
 
 	Disk Bytes `json:"disk,omitempty"`
+
+	// Ranges, both bounds are inclusive and nil leaves them open.
+	// Age is the time since the torrent was added, idle the time since its last activity.
+	MinRatio       *float64  `json:"min_ratio,omitempty"`
+	MaxRatio       *float64  `json:"max_ratio,omitempty"`
+	MinSize        *Bytes    `json:"min_size,omitempty"`
+	MaxSize        *Bytes    `json:"max_size,omitempty"`
+	MinSeedingTime *Duration `json:"min_seeding_time,omitempty"`
+	MaxSeedingTime *Duration `json:"max_seeding_time,omitempty"`
+	MinAge         *Duration `json:"min_age,omitempty"`
+	MaxAge         *Duration `json:"max_age,omitempty"`
+	MinIdle        *Duration `json:"min_idle,omitempty"`
+	MaxIdle        *Duration `json:"max_idle,omitempty"`
+	MinSeeders     *int64    `json:"min_seeders,omitempty"`
+	MaxSeeders     *int64    `json:"max_seeders,omitempty"`
+	MinLeechers    *int64    `json:"min_leechers,omitempty"`
+	MaxLeechers    *int64    `json:"max_leechers,omitempty"`
+	MinProgress    *float64  `json:"min_progress,omitempty"`
+	MaxProgress    *float64  `json:"max_progress,omitempty"`
+
+	// Nested groups, a torrent has to pass every all_of group, at least one any_of group
+	// and must not pass the not group. Disk is ignored in nested groups.
+	AllOf []Filters `json:"all_of,omitempty"`
+	AnyOf []Filters `json:"any_of,omitempty"`
+	Not   *Filters  `json:"not,omitempty"`
+
+	patterns *filterPatterns
+}
+
+// IsZero reports whether the filters select every torrent. Disk only decides
+// whether the strategy runs at all, so it doesn't narrow the selection.
+func (f *Filters) IsZero() bool {
+	v := reflect.ValueOf(*f)
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		if !field.IsExported() || v.Field(i).IsZero() {
+			continue
+		}
+		switch field.Name {
+		case "Disk":
+		case "AllOf":
+			if !slices.ContainsFunc(f.AllOf, func(g Filters) bool { return !g.IsZero() }) {
+				continue
+			}
+			return false
+		case "AnyOf":
+			// a zero group lets every torrent pass
+			if slices.ContainsFunc(f.AnyOf, func(g Filters) bool { return g.IsZero() }) {
+				continue
+			}
+			return false
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// inRanges reports whether the torrent is within every range.
+func (f *Filters) inRanges(t *Torrent, now time.Time) bool {
+	idle := now.Sub(t.LastActivity)
+	if t.LastActivity.IsZero() {
+		idle = now.Sub(t.AddedTime)
+	}
+
+	return inRange(t.Ratio, f.MinRatio, f.MaxRatio) &&
+		inRange(Bytes(t.Size), f.MinSize, f.MaxSize) &&
+		inRange(Duration(t.SeedingTime), f.MinSeedingTime, f.MaxSeedingTime) &&
+		inRange(Duration(now.Sub(t.AddedTime)), f.MinAge, f.MaxAge) &&
+		inRange(Duration(idle), f.MinIdle, f.MaxIdle) &&
+		inRange(t.Seeder, f.MinSeeders, f.MaxSeeders) &&
+		inRange(t.Leecher, f.MinLeechers, f.MaxLeechers) &&
+		inRange(t.Progress, f.MinProgress, f.MaxProgress) &&
+		inRange(len(t.Tags), f.MinTags, f.MaxTags)
+}
+
+func inRange[T cmp.Ordered](v T, min, max *T) bool {
+	return (min == nil || v >= *min) && (max == nil || v <= *max)
+}
+
+// Compile precompiles the patterns of the filters and their nested groups, it's called
+// when the config is read and by FilterTorrents for filters that weren't compiled yet.
+func (f *Filters) Compile() error {
+	if f.NoTags {
+		if f.Untagged != nil && !*f.Untagged {
+			return fmt.Errorf("no_tags and untagged: false contradict each other")
+		}
+		untagged := true
+		f.Untagged = &untagged
+	}
+
+	var p filterPatterns
+	var err error
+	for _, c := range []struct {
+		dst     *[]Pattern
+		values  []string
+		compile func(string) (Pattern, error)
+	}{
+		{&p.names, f.Names, compileRegexp},
+		{&p.categories, f.Categories, CompilePattern},
+		{&p.tags, f.Tags, CompilePattern},
+		{&p.allTags, f.AllTags, CompilePattern},
+		{&p.trackers, f.Trackers, CompilePattern},
+		{&p.excludedNames, f.ExcludedNames, compileRegexp},
+		{&p.excludedCategories, f.ExcludedCategories, CompilePattern},
+		{&p.excludedTags, f.ExcludedTags, CompilePattern},
+		{&p.excludedTrackers, f.ExcludedTrackers, CompilePattern},
+	} {
+		if *c.dst, err = compilePatterns(c.values, c.compile); err != nil {
+			return err
+		}
+	}
+
+	for i := range f.AllOf {
+		if err := f.AllOf[i].Compile(); err != nil {
+			return fmt.Errorf("all_of[%d]: %w", i, err)
+		}
+	}
+	for i := range f.AnyOf {
+		if err := f.AnyOf[i].Compile(); err != nil {
+			return fmt.Errorf("any_of[%d]: %w", i, err)
+		}
+	}
+	if f.Not != nil {
+		if err := f.Not.Compile(); err != nil {
+			return fmt.Errorf("not: %w", err)
+		}
+	}
+
+	f.patterns = &p
+	return nil
 }
 
 // Torrent status
@@ -171,11 +393,64 @@ func (b *Bytes) UnmarshalYAML(buf []byte) error {
 	if err != nil {
 		return err
 	}
+	// negative limits remove a throttle limit, ParseBytes only handles sizes
+	bytesStr, negative := strings.CutPrefix(strings.TrimSpace(bytesStr), "-")
 	bytes, err := utils.ParseBytes(bytesStr)
 	if err != nil {
 		return err
 	}
 
-	*b = Bytes(bytes)
+	*b = Bytes(utils.IfOr(negative, -bytes, bytes))
+	return nil
+}
+
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(buf []byte) error {
+	var durationStr string
+	err := yaml.Unmarshal(buf, &durationStr)
+	if err != nil {
+		return err
+	}
+	duration, err := utils.ParseDuration(durationStr)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(duration)
+	return nil
+}
+
+// GracePeriod is how long a torrent has to keep matching before it is acted on,
+// either a duration or a bare number of consecutive runs.
+type GracePeriod struct {
+	Duration time.Duration
+	Runs     int
+}
+
+func (g GracePeriod) IsZero() bool {
+	return g.Duration == 0 && g.Runs == 0
+}
+
+func (g GracePeriod) Elapsed(since time.Duration, runs int) bool {
+	return since >= g.Duration && runs >= g.Runs
+}
+
+func (g *GracePeriod) UnmarshalYAML(buf []byte) error {
+	var periodStr string
+	err := yaml.Unmarshal(buf, &periodStr)
+	if err != nil {
+		return err
+	}
+	if runs, err := strconv.Atoi(strings.TrimSpace(periodStr)); err == nil {
+		*g = GracePeriod{Runs: runs}
+		return nil
+	}
+	duration, err := utils.ParseDuration(periodStr)
+	if err != nil {
+		return err
+	}
+
+	*g = GracePeriod{Duration: duration}
 	return nil
 }
@@ -1,6 +1,11 @@
 package model
 
-import "testing"
+import (
+	"testing"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
 
 func TestStatus(t *testing.T) {
 	testSt := []struct {
@@ -40,3 +45,25 @@ func TestStatus(t *testing.T) {
 		}
 	}
 }
+
+func TestGracePeriod(t *testing.T) {
+	testSt := []struct {
+		raw      string
+		expected GracePeriod
+	}{
+		{raw: "3", expected: GracePeriod{Runs: 3}},
+		{raw: "2h", expected: GracePeriod{Duration: 2 * time.Hour}},
+		{raw: `"90m"`, expected: GracePeriod{Duration: 90 * time.Minute}},
+	}
+
+	for _, st := range testSt {
+		var g GracePeriod
+		if err := yaml.Unmarshal([]byte(st.raw), &g); err != nil {
+			t.Errorf("failed to unmarshal %q: %v", st.raw, err)
+			continue
+		}
+		if g != st.expected {
+			t.Errorf("expected %+v, got %+v", st.expected, g)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"log/slog"
 	"slices"
 	"time"
 
@@ -28,6 +29,12 @@ type Torrent struct {
 	LastActivity time.Time     `json:"last_activity" expr:"last_activity"`
 	SeedingTime  time.Duration `json:"seeding_time" expr:"seeding_time"`
 	TimeElapsed  time.Duration `json:"time_elapsed" expr:"time_elapsed"`
+	SavePath     string        `json:"save_path" expr:"save_path"`
+	ContentPath  string        `json:"content_path" expr:"content_path"` // the torrent's root file or directory
+
+	// Transmission only
+	Group             string `json:"group" expr:"group"`
+	BandwidthPriority int64  `json:"bandwidth_priority" expr:"bandwidth_priority"`
 
 	Trackers []TorrentTracker `json:"trackers" expr:"trackers"`
 
@@ -43,42 +50,110 @@ func FilterTorrents(f *Filters, freeSpace Bytes, torrents []*Torrent) []*Torrent
 		return nil
 	}
 
-	accesser := func(tt TorrentTracker) string {
-		return tt.URL
+	return FilterTorrentsFunc(f, func(*Torrent) Bytes { return freeSpace }, torrents)
+}
+
+// FilterTorrentsFunc is FilterTorrents with the free space looked up per torrent,
+// e.g. of the disk the torrent is saved on.
+func FilterTorrentsFunc(f *Filters, freeSpace func(t *Torrent) Bytes, torrents []*Torrent) []*Torrent {
+	if f.patterns == nil {
+		if err := f.Compile(); err != nil {
+			slog.Error("failed to compile filters", "error", err)
+			return nil
+		}
 	}
 
+	now := time.Now()
 	return utils.SlicesFilter(func(t *Torrent) bool {
-		if len(f.ExcludedCategories) > 0 && slices.Contains(f.ExcludedCategories, t.Category) {
-			return false
-		}
-		if len(f.ExcludedTags) > 0 && len(t.Tags) > 0 && utils.SlicesHas(f.ExcludedTags, t.Tags...) {
-			return false
-		}
-		if len(f.ExcludedStatus) > 0 && ContainStatus(f.ExcludedStatus, t.Status) {
-			return false
-		}
-		if len(f.ExcludedTrackers) > 0 && len(t.Trackers) > 0 && utils.SlicesHasSubstringsFunc(t.Trackers, accesser, f.ExcludedTrackers...) {
-			return false
-		}
-		if len(f.Categories) > 0 && !slices.Contains(f.Categories, t.Category) {
-			return false
-		}
-		if len(f.Tags) > 0 && !utils.SlicesHas(f.Tags, t.Tags...) {
+		if f.Disk != 0 && freeSpace(t) > f.Disk {
 			return false
 		}
-		if len(f.Status) > 0 && !ContainStatus(f.Status, t.Status) {
+		return f.match(t, now)
+	}, torrents)
+}
+
+// match reports whether the torrent passes the filters and their nested groups.
+func (f *Filters) match(t *Torrent, now time.Time) bool {
+	p := f.patterns
+
+	if len(p.excludedNames) > 0 && matchAny(p.excludedNames, t.Name, Pattern.Match) {
+		return false
+	}
+	if len(p.excludedCategories) > 0 && matchAny(p.excludedCategories, t.Category, Pattern.Match) {
+		return false
+	}
+	if len(p.excludedTags) > 0 && tagMatches(p.excludedTags, t.Tags) {
+		return false
+	}
+	if len(f.ExcludedStatus) > 0 && ContainStatus(f.ExcludedStatus, t.Status) {
+		return false
+	}
+	if len(p.excludedTrackers) > 0 && trackerMatches(p.excludedTrackers, t.Trackers) {
+		return false
+	}
+	if len(p.names) > 0 && !matchAny(p.names, t.Name, Pattern.Match) {
+		return false
+	}
+	if len(p.categories) > 0 && !matchAny(p.categories, t.Category, Pattern.Match) {
+		return false
+	}
+	if len(p.tags) > 0 && !tagMatches(p.tags, t.Tags) {
+		return false
+	}
+	if len(p.allTags) > 0 && !allTagsMatch(p.allTags, t.Tags) {
+		return false
+	}
+	if f.Untagged != nil && *f.Untagged == (len(t.Tags) > 0) {
+		return false
+	}
+	if len(f.Status) > 0 && !ContainStatus(f.Status, t.Status) {
+		return false
+	}
+	if len(p.trackers) > 0 && !trackerMatches(p.trackers, t.Trackers) {
+		return false
+	}
+	if !f.inRanges(t, now) {
+		return false
+	}
+
+	for i := range f.AllOf {
+		if !f.AllOf[i].match(t, now) {
 			return false
 		}
-		if len(f.Trackers) > 0 && !utils.SlicesHasSubstringsFunc(t.Trackers, accesser, f.Trackers...) {
+	}
+	if len(f.AnyOf) > 0 && !slices.ContainsFunc(f.AnyOf, func(g Filters) bool { return g.match(t, now) }) {
+		return false
+	}
+	if f.Not != nil && f.Not.match(t, now) {
+		return false
+	}
+
+	return true
+}
+
+func allTagsMatch(patterns []Pattern, tags []string) bool {
+	for _, p := range patterns {
+		if !slices.ContainsFunc(tags, p.Match) {
 			return false
 		}
+	}
+	return true
+}
 
-		return true
-	}, torrents)
+func trackerMatches(patterns []Pattern, trackers []TorrentTracker) bool {
+	return slices.ContainsFunc(trackers, func(tt TorrentTracker) bool {
+		return matchAny(patterns, tt.URL, Pattern.Contains)
+	})
+}
+
+func tagMatches(patterns []Pattern, tags []string) bool {
+	return slices.ContainsFunc(tags, func(tag string) bool {
+		return matchAny(patterns, tag, Pattern.Match)
+	})
 }
 
 type TorrentTracker struct {
-	URL     string `json:"url" expr:"url"`
-	Status  int    `json:"status" expr:"status"`
-	Message string `json:"message" expr:"message"`
+	URL     string        `json:"url" expr:"url"`
+	Status  TrackerStatus `json:"status" expr:"status"`
+	Message string        `json:"message" expr:"message"`
 }
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"path"
 	"slices"
 	"strings"
 	"time"
@@ -12,6 +13,14 @@ import (
 	"github.com/swkisdust/torrentremover/internal/utils"
 )
 
+// QbitShareLimits are a qBittorrent torrent's current share limits, kept in ClientData
+// since qBittorrent sets the ratio and seeding time limits together.
+// -2 means the global limit and -1 no limit, the seeding time is in minutes.
+type QbitShareLimits struct {
+	Ratio       float64
+	SeedingTime int64
+}
+
 func FromQbit(torrent *qbittorrent.Torrent, prop *qbittorrent.TorrentProperties) *Torrent {
 	return &Torrent{
 		AddedTime:    time.Unix(torrent.AddedOn, 0),
@@ -19,12 +28,14 @@ func FromQbit(torrent *qbittorrent.Torrent, prop *qbittorrent.TorrentProperties)
 		TimeElapsed:  time.Duration(prop.TimeElapsed) * time.Second,
 		SeedingTime:  time.Duration(prop.SeedingTime) * time.Second,
 		Hash:         torrent.Hash,
+		SavePath:     torrent.SavePath,
+		ContentPath:  torrent.ContentPath,
 		Name:         torrent.Name,
 		Status:       GetStatus(string(torrent.State)),
 		Ratio:        torrent.Ratio,
 		Progress:     torrent.Progress * 100,
 		Category:     torrent.Category,
-		Tags:         strings.Split(torrent.Tags, ","),
+		Tags:         splitTags(torrent.Tags),
 		Size:         torrent.Size,
 		Leecher:      int64(prop.PeersTotal),
 		Seeder:       int64(prop.SeedsTotal),
@@ -34,29 +45,47 @@ func FromQbit(torrent *qbittorrent.Torrent, prop *qbittorrent.TorrentProperties)
 		AvgUpSpeed:   int64(prop.UpSpeedAvg),
 		Downloaded:   torrent.Downloaded,
 		Uploaded:     torrent.Uploaded,
+		ClientData:   QbitShareLimits{Ratio: torrent.RatioLimit, SeedingTime: torrent.SeedingTimeLimit},
 		Trackers: utils.SlicesMap(torrent.Trackers,
 			func(qt qbittorrent.TorrentTracker) TorrentTracker {
 				return TorrentTracker{
 					URL:     qt.Url,
-					Status:  int(qt.Status),
+					Status:  FromQbitTrackerStatus(qt.Status),
 					Message: qt.Message,
 				}
 			}),
 	}
 }
 
-func FromTrans(torrent *transmissionrpc.Torrent) *Torrent {
+func FromTrans(torrent *transmissionrpc.Torrent, category string) *Torrent {
+	// group is not returned before RPC v17
+	var group string
+	if torrent.Group != nil {
+		group = *torrent.Group
+	}
+	var bandwidthPriority int64
+	if torrent.BandwidthPriority != nil {
+		bandwidthPriority = *torrent.BandwidthPriority
+	}
+	var savePath string
+	if torrent.DownloadDir != nil {
+		savePath = *torrent.DownloadDir
+	}
+
 	return &Torrent{
 		AddedTime:    *torrent.AddedDate,
 		LastActivity: utils.IfOr(torrent.ActivityDate.Unix() == 0, time.Time{}, *torrent.ActivityDate),
 		TimeElapsed:  time.Since(*torrent.AddedDate),
 		SeedingTime:  *torrent.TimeSeeding,
 		Hash:         *torrent.HashString,
+		SavePath:     savePath,
+		ContentPath:  contentPath(savePath, *torrent.Name),
 		Name:         *torrent.Name,
 		Status:       FromTrStatus(*torrent.Status),
 		Ratio:        *torrent.UploadRatio,
 		Progress:     *torrent.PercentDone * 100,
-		Tags:         torrent.Labels,
+		Category:     category,
+		Tags:         utils.SlicesFilter(func(l string) bool { return l != "" }, torrent.Labels),
 		Size:         int64(torrent.TotalSize.Byte()),
 		Leecher: utils.Reduce(func(sum int64, v transmissionrpc.TrackerStats) int64 {
 			return sum + v.LeecherCount
@@ -64,17 +93,19 @@ func FromTrans(torrent *transmissionrpc.Torrent) *Torrent {
 		Seeder: utils.Reduce(func(sum int64, v transmissionrpc.TrackerStats) int64 {
 			return sum + v.SeederCount
 		}, 0, slices.Values(torrent.TrackerStats)),
-		DlSpeed:    *torrent.RateDownload,
-		UpSpeed:    *torrent.RateUpload,
-		AvgDlSpeed: utils.SafeDivide(*torrent.DownloadedEver, int64(torrent.TimeDownloading.Seconds())),
-		AvgUpSpeed: utils.SafeDivide(*torrent.UploadedEver, int64(torrent.TimeSeeding.Seconds())),
-		Downloaded: *torrent.DownloadedEver,
-		Uploaded:   *torrent.UploadedEver,
+		DlSpeed:           *torrent.RateDownload,
+		UpSpeed:           *torrent.RateUpload,
+		AvgDlSpeed:        utils.SafeDivide(*torrent.DownloadedEver, int64(torrent.TimeDownloading.Seconds())),
+		AvgUpSpeed:        utils.SafeDivide(*torrent.UploadedEver, int64(torrent.TimeSeeding.Seconds())),
+		Downloaded:        *torrent.DownloadedEver,
+		Uploaded:          *torrent.UploadedEver,
+		Group:             group,
+		BandwidthPriority: bandwidthPriority,
 		Trackers: utils.SlicesMap(torrent.TrackerStats,
 			func(tt transmissionrpc.TrackerStats) TorrentTracker {
 				return TorrentTracker{
 					URL:     tt.Announce,
-					Status:  int(tt.AnnounceState),
+					Status:  FromTrTrackerStatus(&tt),
 					Message: tt.LastAnnounceResult,
 				}
 			}),
@@ -83,20 +114,51 @@ func FromTrans(torrent *transmissionrpc.Torrent) *Torrent {
 	}
 }
 
-func FromDeluge(ts *deluge.TorrentStatus, label string) *Torrent {
+// DelugeExtra holds the torrent status fields go-deluge doesn't request.
+type DelugeExtra struct {
+	Label             string   // from the Label or LabelPlus plugin
+	Trackers          []string // announce URLs
+	TimeSinceTransfer int64    // seconds, -1 if unknown or never
+}
+
+func FromDeluge(ts *deluge.TorrentStatus, extra DelugeExtra) *Torrent {
 	addedTime := time.Unix(int64(ts.TimeAdded), 0)
+	trackerStatus, trackerMessage := ParseDelugeTrackerStatus(ts.TrackerStatus)
+
+	// deluge only reports the status of the tracker it announced to last
+	trackers := []TorrentTracker{
+		{
+			URL:     ts.TrackerHost,
+			Status:  trackerStatus,
+			Message: trackerMessage,
+		},
+	}
+	if len(extra.Trackers) > 0 {
+		current := slices.IndexFunc(extra.Trackers, func(url string) bool {
+			return ts.TrackerHost != "" && strings.Contains(url, ts.TrackerHost)
+		})
+		trackers = utils.SlicesMap(extra.Trackers, func(url string) TorrentTracker {
+			return TorrentTracker{URL: url, Status: TrackerNotContacted}
+		})
+		if current != -1 {
+			trackers[current].Status = trackerStatus
+			trackers[current].Message = trackerMessage
+		}
+	}
 
 	return &Torrent{
 		AddedTime:    addedTime,
-		LastActivity: utils.IfOr(ts.LastSeenComplete == 0, time.Time{}, time.Unix(ts.LastSeenComplete, 0)),
+		LastActivity: utils.IfOr(extra.TimeSinceTransfer < 0, time.Time{}, time.Now().Add(-time.Duration(extra.TimeSinceTransfer)*time.Second)),
 		TimeElapsed:  time.Since(addedTime),
 		SeedingTime:  time.Duration(ts.SeedingTime) * time.Second,
 		Hash:         ts.Hash,
+		SavePath:     ts.SavePath,
+		ContentPath:  contentPath(ts.SavePath, ts.Name),
 		Name:         ts.Name,
 		Status:       GetStatus(ts.State),
 		Ratio:        float64(ts.Ratio),
 		Progress:     float64(ts.Progress),
-		Category:     label,
+		Category:     extra.Label,
 		Size:         ts.TotalSize,
 		Leecher:      ts.TotalPeers,
 		Seeder:       ts.TotalSeeds,
@@ -106,11 +168,26 @@ func FromDeluge(ts *deluge.TorrentStatus, label string) *Torrent {
 		AvgDlSpeed:   utils.SafeDivide(ts.AllTimeDownload, (ts.ActiveTime - ts.CompletedTime)),
 		Downloaded:   ts.AllTimeDownload,
 		Uploaded:     ts.TotalUploaded,
-		Trackers: []TorrentTracker{
-			{
-				URL:     ts.TrackerHost,
-				Message: ts.TrackerStatus,
-			},
-		},
+		Trackers:     trackers,
+	}
+}
+
+// splitTags splits qBittorrent's comma separated tags, "" is no tags at all.
+func splitTags(s string) []string {
+	tags := make([]string, 0, strings.Count(s, ",")+1)
+	for tag := range strings.SplitSeq(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// contentPath joins a save path and the torrent name for clients that don't report
+// the content path, it's empty if the save path is unknown.
+func contentPath(savePath, name string) string {
+	if savePath == "" {
+		return ""
 	}
+	return path.Join(savePath, name)
 }
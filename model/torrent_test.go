@@ -5,6 +5,10 @@ import (
 	"slices"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/autobrr/go-deluge"
+	"github.com/goccy/go-yaml"
 
 	"github.com/swkisdust/torrentremover/internal/utils"
 )
@@ -123,6 +127,81 @@ func TestFilterTorrents(t *testing.T) {
 			input:    testTorrents,
 			expected: []*Torrent{testTorrents[0]},
 		},
+		{
+			name:     "Include names matching a regex",
+			filter:   Filters{Names: []string{`^TV Show [BF]$`}},
+			input:    testTorrents,
+			expected: []*Torrent{testTorrents[1], testTorrents[5]},
+		},
+		{
+			name:     "Exclude names matching a regex",
+			filter:   Filters{ExcludedNames: []string{`re:(?i)^movie`}},
+			input:    testTorrents,
+			expected: []*Torrent{testTorrents[1], testTorrents[2], testTorrents[3], testTorrents[5], testTorrents[6]},
+		},
+		{
+			name:     "Include categories matching a glob",
+			filter:   Filters{Categories: []string{"glob:TV*", "glob:M?sic"}},
+			input:    testTorrents,
+			expected: []*Torrent{testTorrents[1], testTorrents[3], testTorrents[5], testTorrents[6]},
+		},
+		{
+			name:     "Exclude tags matching a glob",
+			filter:   Filters{ExcludedTags: []string{"glob:[hs]d"}},
+			input:    testTorrents,
+			expected: []*Torrent{testTorrents[1], testTorrents[2], testTorrents[3], testTorrents[5]},
+		},
+		{
+			name:     "Include trackers matching a regex",
+			filter:   Filters{Trackers: []string{`re:^tracker\.[cd]\.com$`}},
+			input:    testTorrents,
+			expected: []*Torrent{testTorrents[1], testTorrents[2]},
+		},
+		{
+			name:     "Glob doesn't match a substring",
+			filter:   Filters{Trackers: []string{"glob:a.com*"}},
+			input:    testTorrents,
+			expected: []*Torrent{},
+		},
+		{
+			name:     "Invalid pattern matches nothing",
+			filter:   Filters{Names: []string{`re:(`}},
+			input:    testTorrents,
+			expected: nil,
+		},
+		{
+			name:     "Group: 'Movies' category AND ('hd' tag OR 'tracker.f.com' tracker)",
+			filter:   Filters{Categories: []string{"Movies"}, AnyOf: []Filters{{Tags: []string{"hd"}}, {Trackers: []string{"tracker.f.com"}}}},
+			input:    testTorrents,
+			expected: []*Torrent{testTorrents[0], testTorrents[4]},
+		},
+		{
+			name:     "Group: all_of with nested exclusions",
+			filter:   Filters{AllOf: []Filters{{Trackers: []string{"tracker.a.com"}}, {ExcludedStatus: []Status{StatusError}}}},
+			input:    testTorrents,
+			expected: []*Torrent{testTorrents[0], testTorrents[4]},
+		},
+		{
+			name:     "Group: not ('TV Shows' category AND 'hd' tag)",
+			filter:   Filters{Not: &Filters{Categories: []string{"TV Shows"}, Tags: []string{"hd"}}},
+			input:    testTorrents,
+			expected: []*Torrent{testTorrents[0], testTorrents[1], testTorrents[2], testTorrents[3], testTorrents[4], testTorrents[5]},
+		},
+		{
+			name: "Group: any_of containing a not",
+			filter: Filters{
+				Status: []Status{StatusUploading},
+				AnyOf:  []Filters{{Categories: []string{"Music"}}, {Not: &Filters{Trackers: []string{"tracker.a.com"}}}},
+			},
+			input:    testTorrents,
+			expected: []*Torrent{testTorrents[3], testTorrents[5]},
+		},
+		{
+			name:     "Group: invalid nested pattern matches nothing",
+			filter:   Filters{AnyOf: []Filters{{Not: &Filters{Categories: []string{"re:["}}}}},
+			input:    testTorrents,
+			expected: nil,
+		},
 		{
 			name:     "Disk filter: Has more remaining space",
 			filter:   Filters{Disk: 1024},
@@ -176,3 +255,177 @@ func toTorrentTrackers(hosts []string) []TorrentTracker {
 		}
 	})
 }
+
+func TestFromDeluge(t *testing.T) {
+	ts := &deluge.TorrentStatus{
+		Hash:          "test",
+		TrackerHost:   "tracker.b.com",
+		TrackerStatus: "Error: Unregistered torrent",
+	}
+
+	torrent := FromDeluge(ts, DelugeExtra{
+		Label:             "movies",
+		Trackers:          []string{"https://tracker.a.com/announce", "https://tracker.b.com/announce"},
+		TimeSinceTransfer: 3600,
+	})
+
+	expected := []TorrentTracker{
+		{URL: "https://tracker.a.com/announce", Status: TrackerNotContacted},
+		{URL: "https://tracker.b.com/announce", Status: TrackerError, Message: "Unregistered torrent"},
+	}
+	if !reflect.DeepEqual(torrent.Trackers, expected) {
+		t.Errorf("expected trackers %v, got %v", expected, torrent.Trackers)
+	}
+	if torrent.Category != "movies" {
+		t.Errorf("expected category movies, got %q", torrent.Category)
+	}
+	if idle := time.Since(torrent.LastActivity); idle < time.Hour || idle > time.Hour+time.Minute {
+		t.Errorf("expected last activity an hour ago, got %v", torrent.LastActivity)
+	}
+
+	torrent = FromDeluge(ts, DelugeExtra{TimeSinceTransfer: -1})
+	if !torrent.LastActivity.IsZero() {
+		t.Errorf("expected zero last activity, got %v", torrent.LastActivity)
+	}
+	if len(torrent.Trackers) != 1 || torrent.Trackers[0].URL != "tracker.b.com" {
+		t.Errorf("expected fallback tracker, got %v", torrent.Trackers)
+	}
+}
+
+func TestFilterTorrentsRanges(t *testing.T) {
+	now := time.Now()
+	testTorrents := []*Torrent{
+		{Name: "a", Ratio: 0.5, Size: 1 << 30, SeedingTime: time.Hour, AddedTime: now.Add(-2 * time.Hour), LastActivity: now.Add(-time.Minute), Seeder: 0, Leecher: 3, Progress: 100},
+		{Name: "b", Ratio: 2, Size: 10 << 30, SeedingTime: 72 * time.Hour, AddedTime: now.Add(-96 * time.Hour), Seeder: 12, Leecher: 0, Progress: 100},
+		{Name: "c", Ratio: 1, Size: 100 << 20, AddedTime: now.Add(-time.Hour), LastActivity: now.Add(-30 * time.Minute), Seeder: 5, Leecher: 1, Progress: 42},
+	}
+	ptr := func(v float64) *float64 { return &v }
+	bytes := func(v Bytes) *Bytes { return &v }
+	duration := func(v time.Duration) *Duration { d := Duration(v); return &d }
+	count := func(v int64) *int64 { return &v }
+
+	tests := []struct {
+		name     string
+		filter   Filters
+		expected []*Torrent
+	}{
+		{"Min ratio", Filters{MinRatio: ptr(1)}, []*Torrent{testTorrents[1], testTorrents[2]}},
+		{"Ratio range", Filters{MinRatio: ptr(0.5), MaxRatio: ptr(1)}, []*Torrent{testTorrents[0], testTorrents[2]}},
+		{"Max size", Filters{MaxSize: bytes(1 << 30)}, []*Torrent{testTorrents[0], testTorrents[2]}},
+		{"Min seeding time", Filters{MinSeedingTime: duration(24 * time.Hour)}, []*Torrent{testTorrents[1]}},
+		{"Min age", Filters{MinAge: duration(90 * time.Minute)}, []*Torrent{testTorrents[0], testTorrents[1]}},
+		{"Min idle falls back to added time", Filters{MinIdle: duration(10 * time.Minute)}, []*Torrent{testTorrents[1], testTorrents[2]}},
+		{"No seeders", Filters{MaxSeeders: count(0)}, []*Torrent{testTorrents[0]}},
+		{"Min leechers", Filters{MinLeechers: count(1)}, []*Torrent{testTorrents[0], testTorrents[2]}},
+		{"Incomplete", Filters{MaxProgress: ptr(99.9)}, []*Torrent{testTorrents[2]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FilterTorrents(&tt.filter, 2048, testTorrents)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("FilterTorrents() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+
+	if !(&Filters{}).IsZero() || (&Filters{MaxSeeders: count(0)}).IsZero() {
+		t.Error("IsZero should only be true for empty filters")
+	}
+	if !(&Filters{Disk: 1024, AllOf: []Filters{{Disk: 1024}}}).IsZero() {
+		t.Error("IsZero should ignore disk, which doesn't narrow the selection")
+	}
+	if !(&Filters{AnyOf: []Filters{{}, {MaxSeeders: count(0)}}}).IsZero() {
+		t.Error("IsZero should be true if any any_of group lets every torrent pass")
+	}
+}
+
+func TestFiltersYAML(t *testing.T) {
+	const raw = `
+categories: movies
+any_of:
+  - tags: [hd, 4k]
+  - trackers: tracker.a.com
+    not:
+      names: "(?i)sample"
+`
+	var f Filters
+	if err := yaml.Unmarshal([]byte(raw), &f); err != nil {
+		t.Fatalf("failed to unmarshal filters: %v", err)
+	}
+	if err := f.Compile(); err != nil {
+		t.Fatalf("failed to compile filters: %v", err)
+	}
+
+	torrents := []*Torrent{
+		{Name: "A", Category: "movies", Tags: []string{"4k"}},
+		{Name: "B", Category: "movies", Trackers: toTorrentTrackers([]string{"tracker.a.com"})},
+		{Name: "B Sample", Category: "movies", Trackers: toTorrentTrackers([]string{"tracker.a.com"})},
+		{Name: "C", Category: "movies"},
+	}
+	got := FilterTorrents(&f, 0, torrents)
+	if expected := torrents[:2]; !reflect.DeepEqual(got, expected) {
+		t.Errorf("FilterTorrents() = %v, want %v", got, expected)
+	}
+}
+
+func TestFilterTorrentsTags(t *testing.T) {
+	testTorrents := []*Torrent{
+		{Name: "a", Tags: []string{"hd", "2023", "public"}},
+		{Name: "b", Tags: []string{"hd"}},
+		{Name: "c", Tags: []string{}},
+		{Name: "d", Tags: nil},
+	}
+	yes, no, two := true, false, 2
+
+	tests := []struct {
+		name     string
+		filter   Filters
+		expected []*Torrent
+	}{
+		{"All tags", Filters{AllTags: []string{"hd", "2023"}}, testTorrents[:1]},
+		{"All tags with a glob", Filters{AllTags: []string{"hd", "glob:20*"}}, testTorrents[:1]},
+		{"Any tag", Filters{Tags: []string{"hd", "2023"}}, testTorrents[:2]},
+		{"No tags", Filters{NoTags: true}, testTorrents[2:]},
+		{"Untagged", Filters{Untagged: &yes}, testTorrents[2:]},
+		{"Tagged", Filters{Untagged: &no}, testTorrents[:2]},
+		{"Min tags", Filters{MinTags: &two}, testTorrents[:1]},
+		{"Max tags", Filters{MaxTags: &two, ExcludedTags: []string{"hd"}}, testTorrents[2:]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FilterTorrents(&tt.filter, 0, testTorrents); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("FilterTorrents() = %v, want %v", utils.SlicesMap(got, func(t *Torrent) string { return t.Name }),
+					utils.SlicesMap(tt.expected, func(t *Torrent) string { return t.Name }))
+			}
+		})
+	}
+}
+
+func TestSplitTags(t *testing.T) {
+	tests := map[string][]string{
+		"":             {},
+		"hd":           {"hd"},
+		"hd, 2023":     {"hd", "2023"},
+		" hd ,, 2023 ": {"hd", "2023"},
+	}
+	for raw, expected := range tests {
+		if got := splitTags(raw); !reflect.DeepEqual(got, expected) {
+			t.Errorf("splitTags(%q) = %q, want %q", raw, got, expected)
+		}
+	}
+}
+
+func TestFilterTorrentsFunc(t *testing.T) {
+	testTorrents := []*Torrent{
+		{Name: "a", SavePath: "/mnt/disk1"},
+		{Name: "b", SavePath: "/mnt/disk2"},
+	}
+	free := map[string]Bytes{"/mnt/disk1": 1024, "/mnt/disk2": 4096}
+
+	got := FilterTorrentsFunc(&Filters{Disk: 2048}, func(t *Torrent) Bytes { return free[t.SavePath] }, testTorrents)
+	if expected := testTorrents[:1]; !reflect.DeepEqual(got, expected) {
+		t.Errorf("FilterTorrentsFunc() = %v, want %v", got, expected)
+	}
+}
@@ -0,0 +1,124 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/autobrr/go-qbittorrent"
+	"github.com/hekmon/transmissionrpc/v3"
+)
+
+// Tracker status, normalized across clients.
+// The values of the first five match qBittorrent's TrackerStatus.
+type TrackerStatus uint8
+
+const (
+	TrackerDisabled TrackerStatus = iota
+	TrackerNotContacted
+	TrackerWorking
+	TrackerUpdating
+	TrackerNotWorking
+	TrackerError
+)
+
+var trackerStatusNames = [...]string{
+	TrackerDisabled:     "disabled",
+	TrackerNotContacted: "not_contacted",
+	TrackerWorking:      "working",
+	TrackerUpdating:     "updating",
+	TrackerNotWorking:   "not_working",
+	TrackerError:        "error",
+}
+
+// TrackerStatuses maps every status name to its value, exposed to expr.
+var TrackerStatuses = func() map[string]TrackerStatus {
+	m := make(map[string]TrackerStatus, len(trackerStatusNames))
+	for status, name := range trackerStatusNames {
+		m[name] = TrackerStatus(status)
+	}
+	return m
+}()
+
+func (s TrackerStatus) String() string {
+	if int(s) < len(trackerStatusNames) {
+		return trackerStatusNames[s]
+	}
+	return fmt.Sprintf("TrackerStatus(%d)", s)
+}
+
+func FromQbitTrackerStatus(status qbittorrent.TrackerStatus) TrackerStatus {
+	switch status {
+	case qbittorrent.TrackerStatusDisabled:
+		return TrackerDisabled
+	case qbittorrent.TrackerStatusNotContacted:
+		return TrackerNotContacted
+	case qbittorrent.TrackerStatusOK:
+		return TrackerWorking
+	case qbittorrent.TrackerStatusUpdating:
+		return TrackerUpdating
+	case 5: // qBittorrent 5.1 tracker error
+		return TrackerError
+	default: // not working and qBittorrent 5.1 unreachable
+		return TrackerNotWorking
+	}
+}
+
+// Transmission announce states, see tr_tracker_state
+const (
+	trTrackerInactive = iota
+	trTrackerWaiting
+	trTrackerQueued
+	trTrackerActive
+)
+
+func FromTrTrackerStatus(ts *transmissionrpc.TrackerStats) TrackerStatus {
+	switch ts.AnnounceState {
+	case trTrackerInactive:
+		return TrackerDisabled
+	case trTrackerQueued, trTrackerActive:
+		return TrackerUpdating
+	}
+
+	switch {
+	case !ts.HasAnnounced:
+		return TrackerNotContacted
+	case ts.LastAnnounceSucceeded:
+		return TrackerWorking
+	case ts.LastAnnounceTimedOut:
+		return TrackerNotWorking
+	default:
+		return TrackerError
+	}
+}
+
+// ParseDelugeTrackerStatus splits deluge's tracker status string,
+// e.g. "Announce OK" or "Error: unregistered torrent", into a status and a message.
+func ParseDelugeTrackerStatus(s string) (TrackerStatus, string) {
+	kind, msg, found := strings.Cut(s, ":")
+	if !found {
+		switch strings.TrimSpace(s) {
+		case "":
+			return TrackerNotContacted, ""
+		case "Announce OK":
+			return TrackerWorking, s
+		case "Announce Sent":
+			return TrackerUpdating, s
+		default:
+			return TrackerNotWorking, s
+		}
+	}
+
+	msg = strings.TrimSpace(msg)
+	switch strings.TrimSpace(kind) {
+	case "Warning":
+		// the tracker replied, it just had something to say
+		return TrackerWorking, msg
+	case "Error":
+		if strings.Contains(strings.ToLower(msg), "timed out") {
+			return TrackerNotWorking, msg
+		}
+		return TrackerError, msg
+	default:
+		return TrackerNotWorking, s
+	}
+}
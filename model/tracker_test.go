@@ -0,0 +1,25 @@
+package model
+
+import "testing"
+
+func TestParseDelugeTrackerStatus(t *testing.T) {
+	testSt := []struct {
+		raw      string
+		expected TrackerStatus
+		message  string
+	}{
+		{raw: "", expected: TrackerNotContacted, message: ""},
+		{raw: "Announce OK", expected: TrackerWorking, message: "Announce OK"},
+		{raw: "Announce Sent", expected: TrackerUpdating, message: "Announce Sent"},
+		{raw: "Warning: min interval not reached", expected: TrackerWorking, message: "min interval not reached"},
+		{raw: "Error: Unregistered torrent", expected: TrackerError, message: "Unregistered torrent"},
+		{raw: "Error: timed out", expected: TrackerNotWorking, message: "timed out"},
+	}
+
+	for _, st := range testSt {
+		status, msg := ParseDelugeTrackerStatus(st.raw)
+		if status != st.expected || msg != st.message {
+			t.Errorf("%q: expected %v %q, got %v %q", st.raw, st.expected, st.message, status, msg)
+		}
+	}
+}